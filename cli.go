@@ -0,0 +1,494 @@
+/*
+ * Copyright (C) 2025  GeorgH93
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"micro_geoip/internal/config"
+	"micro_geoip/internal/geoip"
+	"micro_geoip/internal/logging"
+)
+
+// usage prints the available commands
+func usage(w io.Writer) {
+	fmt.Fprint(w, `Usage: micro_geoip [command] [arguments]
+
+Commands:
+  serve                       Run the API server (default)
+  lookup [-format f] [ip...]  Look up IPs from the arguments or stdin, f is table, csv or json
+  enrich [options] [file...]  Add the country to access logs from the files or stdin, see enrich -h
+  networks [options] <code>   Export the networks of countries, see networks -h
+  update                      Download new releases of the databases and exit
+  verify <file>               Print the metadata of a database file and check it
+  help                        Show this help
+
+All commands use the configuration of the server (config.yaml and environment).
+`)
+}
+
+// lookupColumns are the columns of the lookup output, location and ASN
+// columns are only included if the databases are loaded
+var lookupColumns = struct {
+	base, location, asn []string
+}{
+	base:     []string{"ip", "country_code", "country", "network"},
+	location: []string{"city", "region"},
+	asn:      []string{"asn", "as_org"},
+}
+
+// lookupRecord is a row of the lookup output
+type lookupRecord struct {
+	IP          string `json:"ip"`
+	CountryCode string `json:"country_code,omitempty"`
+	Country     string `json:"country,omitempty"`
+	Network     string `json:"network,omitempty"`
+	City        string `json:"city,omitempty"`
+	Region      string `json:"region,omitempty"`
+	ASN         uint   `json:"asn,omitempty"`
+	ASOrg       string `json:"as_org,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// value returns the value of a column
+func (r *lookupRecord) value(column string) string {
+	switch column {
+	case "ip":
+		return r.IP
+	case "country_code":
+		return r.CountryCode
+	case "country":
+		return r.Country
+	case "network":
+		return r.Network
+	case "city":
+		return r.City
+	case "region":
+		return r.Region
+	case "asn":
+		if r.ASN == 0 {
+			return ""
+		}
+		return strconv.FormatUint(uint64(r.ASN), 10)
+	case "as_org":
+		return r.ASOrg
+	case "error":
+		return r.Error
+	}
+	return ""
+}
+
+// recordWriter writes lookup records in one of the output formats
+type recordWriter interface {
+	Write(record *lookupRecord) error
+	Flush() error
+}
+
+func newRecordWriter(format string, w io.Writer, columns []string) (recordWriter, error) {
+	switch format {
+	case "json":
+		return &jsonRecordWriter{encoder: json.NewEncoder(w)}, nil
+	case "csv":
+		return &csvRecordWriter{writer: csv.NewWriter(w), columns: columns}, nil
+	case "table":
+		return &tableRecordWriter{writer: tabwriter.NewWriter(w, 0, 0, 2, ' ', 0), columns: columns}, nil
+	default:
+		return nil, fmt.Errorf("unknown output format: %s", format)
+	}
+}
+
+// jsonRecordWriter writes one JSON object per line
+type jsonRecordWriter struct {
+	encoder *json.Encoder
+}
+
+func (w *jsonRecordWriter) Write(record *lookupRecord) error {
+	return w.encoder.Encode(record)
+}
+
+func (w *jsonRecordWriter) Flush() error {
+	return nil
+}
+
+type csvRecordWriter struct {
+	writer  *csv.Writer
+	columns []string
+	started bool
+}
+
+func (w *csvRecordWriter) Write(record *lookupRecord) error {
+	if !w.started {
+		w.started = true
+		if err := w.writer.Write(w.columns); err != nil {
+			return err
+		}
+	}
+
+	values := make([]string, len(w.columns))
+	for i, column := range w.columns {
+		values[i] = record.value(column)
+	}
+	return w.writer.Write(values)
+}
+
+func (w *csvRecordWriter) Flush() error {
+	w.writer.Flush()
+	return w.writer.Error()
+}
+
+// tableRecordWriter aligns the columns, so the output is only written on Flush
+type tableRecordWriter struct {
+	writer  *tabwriter.Writer
+	columns []string
+	started bool
+}
+
+func (w *tableRecordWriter) Write(record *lookupRecord) error {
+	if !w.started {
+		w.started = true
+		if _, err := fmt.Fprintln(w.writer, strings.ToUpper(strings.Join(w.columns, "\t"))); err != nil {
+			return err
+		}
+	}
+
+	values := make([]string, len(w.columns))
+	for i, column := range w.columns {
+		values[i] = record.value(column)
+	}
+	_, err := fmt.Fprintln(w.writer, strings.Join(values, "\t"))
+	return err
+}
+
+func (w *tableRecordWriter) Flush() error {
+	return w.writer.Flush()
+}
+
+// lookupCommand looks up the IPs given as arguments, or one per line from
+// stdin if there are none
+func lookupCommand(args []string, stdin io.Reader, stdout io.Writer) int {
+	flags := flag.NewFlagSet("lookup", flag.ContinueOnError)
+	format := flags.String("format", "table", "Output format: table, csv or json")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if _, err := newRecordWriter(*format, io.Discard, nil); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	cfg := loadConfig()
+	quietLogs()
+
+	service, err := openService(cfg)
+	if err != nil {
+		log.Printf("Failed to initialize GeoIP service: %v", err)
+		return 1
+	}
+	defer service.Close()
+
+	ok, err := runLookups(service, *format, flags.Args(), stdin, stdout)
+	if err != nil {
+		log.Printf("Lookup failed: %v", err)
+		return 1
+	}
+	if !ok {
+		return 1
+	}
+	return 0
+}
+
+// runLookups writes a record for each IP in ips, or in input if ips is empty.
+// It reports whether all lookups succeeded.
+func runLookups(service geoip.GeoIPService, format string, ips []string, input io.Reader, output io.Writer) (bool, error) {
+	columns := append([]string(nil), lookupColumns.base...)
+	for _, status := range service.Status() {
+		if !status.Loaded {
+			continue
+		}
+		switch status.Name {
+		case "city":
+			columns = append(columns, lookupColumns.location...)
+		case "asn":
+			columns = append(columns, lookupColumns.asn...)
+		}
+	}
+	columns = append(columns, "error")
+
+	writer, err := newRecordWriter(format, output, columns)
+	if err != nil {
+		return false, err
+	}
+
+	ok := true
+	lookup := func(ip string) error {
+		record := lookupIP(service, ip)
+		if record.Error != "" {
+			ok = false
+		}
+		return writer.Write(record)
+	}
+
+	if len(ips) > 0 {
+		for _, ip := range ips {
+			if err := lookup(ip); err != nil {
+				return false, err
+			}
+		}
+	} else {
+		scanner := bufio.NewScanner(input)
+		for scanner.Scan() {
+			ip := strings.TrimSpace(scanner.Text())
+			if ip == "" || strings.HasPrefix(ip, "#") {
+				continue
+			}
+			if err := lookup(ip); err != nil {
+				return false, err
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return false, err
+		}
+	}
+
+	return ok, writer.Flush()
+}
+
+// lookupIP looks up the country of ip and, if available, its location and
+// autonomous system. The network is the most specific one of the databases,
+// the whole record applies to it.
+func lookupIP(service geoip.GeoIPService, ip string) *lookupRecord {
+	record := &lookupRecord{IP: ip}
+
+	country, err := service.GetCountry(ip)
+	if err != nil {
+		record.Error = err.Error()
+		return record
+	}
+	record.CountryCode = country.Code
+	record.Country = country.Name
+	network := country.Network
+
+	if location, err := service.GetLocation(ip); err == nil {
+		record.City = location.City
+		record.Region = location.Subdivision
+		if location.Network.Bits() > network.Bits() {
+			network = location.Network
+		}
+	}
+	if asn, err := service.GetASN(ip); err == nil {
+		record.ASN = asn.Number
+		record.ASOrg = asn.Organization
+		if asn.Network.Bits() > network.Bits() {
+			network = asn.Network
+		}
+	}
+
+	if network.IsValid() {
+		record.Network = network.String()
+	}
+	return record
+}
+
+// networksCommand prints the networks of the countries given as arguments,
+// e.g. to build firewall rules
+func networksCommand(args []string, stdout io.Writer) int {
+	flags := flag.NewFlagSet("networks", flag.ContinueOnError)
+	format := flags.String("format", "text", "Output format: text (one network per line) or csv")
+	ipVersion := flags.Int("ip-version", 0, "Only export IPv4 (4) or IPv6 (6) networks")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: micro_geoip networks [-ip-version 4|6] [-format text|csv] <country code>...")
+		return 2
+	}
+	if *format != "text" && *format != "csv" {
+		fmt.Fprintf(os.Stderr, "unknown format %q, use text or csv\n", *format)
+		return 2
+	}
+	if *ipVersion != 0 && *ipVersion != 4 && *ipVersion != 6 {
+		fmt.Fprintf(os.Stderr, "invalid IP version %d, use 4 or 6\n", *ipVersion)
+		return 2
+	}
+
+	cfg := loadConfig()
+	quietLogs()
+
+	service, err := openService(cfg)
+	if err != nil {
+		log.Printf("Failed to initialize GeoIP service: %v", err)
+		return 1
+	}
+	defer service.Close()
+
+	if err := exportNetworks(service, flags.Args(), *ipVersion, *format, stdout); err != nil {
+		log.Printf("Export failed: %v", err)
+		return 1
+	}
+	return 0
+}
+
+// exportNetworks writes the networks of each country in codes, csv rows
+// include the country code
+func exportNetworks(service geoip.GeoIPService, codes []string, ipVersion int, format string, output io.Writer) error {
+	writer := csv.NewWriter(output)
+	if format == "csv" {
+		writer.Write([]string{"country_code", "network"})
+	}
+
+	for _, code := range codes {
+		code = strings.ToUpper(code)
+		networks, err := service.CountryNetworks(code, ipVersion)
+		if err != nil {
+			return err
+		}
+
+		for _, network := range networks {
+			if format == "csv" {
+				writer.Write([]string{code, network.String()})
+			} else if _, err := fmt.Fprintln(output, network); err != nil {
+				return err
+			}
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// updateCommand runs the provider chains of all databases once, e.g. from a
+// cron job or an init container
+func updateCommand(args []string) int {
+	flags := flag.NewFlagSet("update", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	cfg := loadConfig()
+	service, err := geoip.OpenService(cfg)
+	if err != nil {
+		log.Printf("Failed to initialize GeoIP service: %v", err)
+		return 1
+	}
+	defer service.Close()
+
+	if err := service.Update(); err != nil {
+		log.Printf("Update failed: %v", err)
+		return 1
+	}
+	return 0
+}
+
+// verifyCommand prints the metadata of a database file and the results of
+// a few sanity lookups
+func verifyCommand(args []string, stdout io.Writer) int {
+	flags := flag.NewFlagSet("verify", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: micro_geoip verify <file>")
+		return 2
+	}
+
+	loadConfig()
+
+	result, err := geoip.VerifyDatabase(flags.Arg(0))
+	if result != nil {
+		printVerifyResult(stdout, result)
+	}
+	if err != nil {
+		fmt.Fprintf(stdout, "\nFAILED: %s\n", geoip.RedactError(err))
+		return 1
+	}
+
+	fmt.Fprintln(stdout, "\nOK")
+	return 0
+}
+
+func printVerifyResult(w io.Writer, result *geoip.VerifyResult) {
+	status := result.Status
+	tw := tabwriter.NewWriter(w, 0, 0, 1, ' ', 0)
+	fmt.Fprintf(tw, "File:\t%s\n", status.Path)
+	fmt.Fprintf(tw, "Type:\t%s\n", status.Type)
+	fmt.Fprintf(tw, "Build time:\t%s\n", status.BuildTime.UTC().Format(time.RFC3339))
+	fmt.Fprintf(tw, "IP version:\t%d\n", status.IPVersion)
+	fmt.Fprintf(tw, "Languages:\t%s\n", strings.Join(status.Languages, ", "))
+	fmt.Fprintf(tw, "Node count:\t%d\n", status.NodeCount)
+	if description, ok := status.Description["en"]; ok {
+		fmt.Fprintf(tw, "Description:\t%s\n", description)
+	}
+	fmt.Fprintf(tw, "SHA-256:\t%s\n", status.Checksum)
+	tw.Flush()
+
+	fmt.Fprintln(w, "\nSanity lookups:")
+	tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, lookup := range result.Lookups {
+		switch {
+		case lookup.Error != "":
+			fmt.Fprintf(tw, "  %s\terror: %s\n", lookup.IP, lookup.Error)
+		case lookup.Result == "":
+			fmt.Fprintf(tw, "  %s\tnot found\n", lookup.IP)
+		default:
+			fmt.Fprintf(tw, "  %s\t%s\n", lookup.IP, lookup.Result)
+		}
+	}
+	tw.Flush()
+}
+
+// openService opens the configured databases, downloading them if any is
+// missing. Only the country database is required for lookups.
+func openService(cfg *config.Config) (*geoip.Service, error) {
+	service, err := geoip.OpenService(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, status := range service.Status() {
+		if !status.Loaded {
+			// Failures are logged, the lookups go ahead with what is loaded
+			service.Update()
+			break
+		}
+	}
+
+	if !service.Status()[0].Loaded {
+		service.Close()
+		return nil, fmt.Errorf("GeoIP country %w", geoip.ErrDatabaseUnavailable)
+	}
+	return service, nil
+}
+
+// quietLogs hides routine messages, so they don't get mixed into the output
+// of commands that print results
+func quietLogs() {
+	if logging.CurrentLevel() < logging.LevelWarn {
+		logging.SetLevel(logging.LevelWarn)
+	}
+}
@@ -0,0 +1,182 @@
+/*
+ * Copyright (C) 2025  GeorgH93
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"micro_geoip/internal/geoip"
+)
+
+func TestRunLookupsCSV(t *testing.T) {
+	service := geoip.NewMockService()
+
+	var output bytes.Buffer
+	ok, err := runLookups(service, "csv", []string{"8.8.8.8", "134.195.196.26"}, nil, &output)
+	if err != nil {
+		t.Fatalf("Lookups failed: %v", err)
+	}
+	if !ok {
+		t.Error("Expected all lookups to succeed")
+	}
+
+	expected := "ip,country_code,country,network,error\n8.8.8.8,US,United States,,\n134.195.196.26,DE,Germany,,\n"
+	if output.String() != expected {
+		t.Errorf("Expected output %q, got %q", expected, output.String())
+	}
+}
+
+func TestRunLookupsFromInput(t *testing.T) {
+	service := geoip.NewMockService()
+	service.SetASN("8.8.8.8", 15169, "GOOGLE")
+	service.DatabaseStatuses = []geoip.DatabaseStatus{
+		{Name: "country", Loaded: true},
+		{Name: "asn", Loaded: true},
+	}
+
+	input := strings.NewReader("# resolvers\n8.8.8.8\n\n  1.1.1.1  \n")
+	var output bytes.Buffer
+	if _, err := runLookups(service, "json", nil, input, &output); err != nil {
+		t.Fatalf("Lookups failed: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 records, got %d: %q", len(lines), output.String())
+	}
+
+	var record lookupRecord
+	if err := json.Unmarshal([]byte(lines[0]), &record); err != nil {
+		t.Fatalf("Failed to parse record: %v", err)
+	}
+	if record.IP != "8.8.8.8" || record.CountryCode != "US" || record.ASN != 15169 || record.ASOrg != "GOOGLE" {
+		t.Errorf("Unexpected record %+v", record)
+	}
+}
+
+func TestRunLookupsTable(t *testing.T) {
+	service := geoip.NewMockService()
+	service.SetLocation("8.8.8.8", &geoip.LocationInfo{
+		CountryInfo: geoip.CountryInfo{Code: "US", Name: "United States"},
+		City:        "Mountain View",
+		Subdivision: "California",
+	})
+	service.DatabaseStatuses = []geoip.DatabaseStatus{
+		{Name: "country", Loaded: true},
+		{Name: "city", Loaded: true},
+	}
+
+	var output bytes.Buffer
+	if _, err := runLookups(service, "table", []string{"8.8.8.8"}, nil, &output); err != nil {
+		t.Fatalf("Lookups failed: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected header and 1 row, got %q", output.String())
+	}
+	if fields := strings.Fields(lines[0]); strings.Join(fields, " ") != "IP COUNTRY_CODE COUNTRY NETWORK CITY REGION ERROR" {
+		t.Errorf("Unexpected header %q", lines[0])
+	}
+	if !strings.Contains(lines[1], "Mountain View") || !strings.Contains(lines[1], "California") {
+		t.Errorf("Expected location in row, got %q", lines[1])
+	}
+}
+
+func TestRunLookupsFailure(t *testing.T) {
+	service := geoip.NewMockService()
+	service.Unavailable = true
+
+	var output bytes.Buffer
+	ok, err := runLookups(service, "json", []string{"8.8.8.8"}, nil, &output)
+	if err != nil {
+		t.Fatalf("Lookups failed: %v", err)
+	}
+	if ok {
+		t.Error("Expected failed lookup to be reported")
+	}
+	if !strings.Contains(output.String(), `"error"`) {
+		t.Errorf("Expected error in output, got %q", output.String())
+	}
+}
+
+func TestRunLookupsUnknownFormat(t *testing.T) {
+	var output bytes.Buffer
+	if _, err := runLookups(geoip.NewMockService(), "xml", []string{"8.8.8.8"}, nil, &output); err == nil {
+		t.Error("Expected error for unknown format")
+	}
+}
+
+func TestVerifyCommandUsage(t *testing.T) {
+	var output bytes.Buffer
+	if code := verifyCommand(nil, &output); code != 2 {
+		t.Errorf("Expected exit code 2 without file, got %d", code)
+	}
+}
+
+func TestVerifyCommandMissingFile(t *testing.T) {
+	var output bytes.Buffer
+	if code := verifyCommand([]string{t.TempDir() + "/missing.mmdb"}, &output); code != 1 {
+		t.Errorf("Expected exit code 1 for missing file, got %d", code)
+	}
+	if !strings.Contains(output.String(), "FAILED") {
+		t.Errorf("Expected failure in output, got %q", output.String())
+	}
+}
+
+func TestExportNetworks(t *testing.T) {
+	service := geoip.NewMockService()
+	service.NetworkMap = map[string][]netip.Prefix{
+		"US": {netip.MustParsePrefix("8.8.0.0/16"), netip.MustParsePrefix("2001:4860::/32")},
+		"DE": {netip.MustParsePrefix("134.195.196.0/22")},
+	}
+
+	var output bytes.Buffer
+	if err := exportNetworks(service, []string{"us", "DE"}, 4, "text", &output); err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	if expected := "8.8.0.0/16\n134.195.196.0/22\n"; output.String() != expected {
+		t.Errorf("Expected output %q, got %q", expected, output.String())
+	}
+
+	output.Reset()
+	if err := exportNetworks(service, []string{"US"}, 0, "csv", &output); err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	if expected := "country_code,network\nUS,8.8.0.0/16\nUS,2001:4860::/32\n"; output.String() != expected {
+		t.Errorf("Expected output %q, got %q", expected, output.String())
+	}
+
+	service.Unavailable = true
+	if err := exportNetworks(service, []string{"US"}, 0, "text", &output); err == nil {
+		t.Error("Expected error without database")
+	}
+}
+
+func TestNetworksCommandUsage(t *testing.T) {
+	for _, args := range [][]string{nil, {"-format", "xml", "US"}, {"-ip-version", "5", "US"}} {
+		var output bytes.Buffer
+		if code := networksCommand(args, &output); code != 2 {
+			t.Errorf("Expected exit code 2 for %v, got %d", args, code)
+		}
+	}
+}
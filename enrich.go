@@ -0,0 +1,474 @@
+/*
+ * Copyright (C) 2025  GeorgH93
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"regexp"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+
+	"micro_geoip/internal/geoip"
+	"micro_geoip/internal/logging"
+)
+
+// defaultEnrichPattern matches the client address at the start of nginx and
+// Apache access log lines
+const defaultEnrichPattern = `^(\S+)`
+
+// enrichOptions configure how the IP is taken from the input records
+type enrichOptions struct {
+	format  string // text, json or csv
+	field   string // regex, JSON key or CSV column with the IP
+	header  bool   // whether CSV input starts with a header
+	workers int
+
+	pattern *regexp.Regexp // field of the text format
+	group   int            // group of pattern with the IP
+	keys    []string       // path of the JSON key
+}
+
+// init validates the options and fills in the defaults of the format
+func (o *enrichOptions) init() error {
+	if o.workers < 1 {
+		return fmt.Errorf("workers must be at least 1")
+	}
+
+	switch o.format {
+	case "text":
+		if o.field == "" {
+			o.field = defaultEnrichPattern
+		}
+		pattern, err := regexp.Compile(o.field)
+		if err != nil {
+			return fmt.Errorf("invalid field pattern: %w", err)
+		}
+		o.pattern = pattern
+
+		// The group named ip, the first group or the whole match
+		if o.group = pattern.SubexpIndex("ip"); o.group < 0 {
+			o.group = min(pattern.NumSubexp(), 1)
+		}
+
+	case "json":
+		if o.field == "" {
+			o.field = "remote_addr"
+		}
+		o.keys = strings.Split(o.field, ".")
+
+	case "csv":
+		if o.field == "" {
+			o.field = "1"
+		}
+		if _, err := strconv.Atoi(o.field); err != nil && !o.header {
+			return fmt.Errorf("CSV input without header needs a column number")
+		}
+
+	default:
+		return fmt.Errorf("unknown input format: %s", o.format)
+	}
+
+	return nil
+}
+
+// enrichJob is a record passed from the reader through a worker to the writer
+type enrichJob struct {
+	line   []byte   // record of the line based formats, without line ending
+	eol    string   // line ending of line
+	fields []string // record of the CSV format
+	header bool     // whether fields is the CSV header
+	done   chan struct{}
+}
+
+// enricher reads the records of a format, adds the country to them and
+// writes them in the same format
+type enricher interface {
+	// read returns the next record or io.EOF
+	read() (*enrichJob, error)
+	// enrich adds the country fields to a record, it is called concurrently
+	enrich(job *enrichJob, lookup func(ip string) *geoip.CountryInfo)
+	write(job *enrichJob) error
+	flush() error
+}
+
+// enrichCommand adds the country to each record of the given files or stdin,
+// keeping their format
+func enrichCommand(args []string, stdin io.Reader, stdout io.Writer) int {
+	flags := flag.NewFlagSet("enrich", flag.ContinueOnError)
+	options := &enrichOptions{}
+	flags.StringVar(&options.format, "format", "text", "Input format: text, json or csv")
+	flags.StringVar(&options.field, "field", "", "Field with the IP: a regex for text (the group named ip or the first group), a key for json (nested keys separated by dots) or a column name or number for csv")
+	flags.BoolVar(&options.header, "header", true, "Whether CSV input starts with a header")
+	flags.IntVar(&options.workers, "workers", runtime.NumCPU(), "Number of concurrent lookups")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if err := options.init(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	inputs := flags.Args()
+	if len(inputs) == 0 {
+		inputs = []string{"-"}
+	}
+
+	cfg := loadConfig()
+	quietLogs()
+
+	service, err := openService(cfg)
+	if err != nil {
+		log.Printf("Failed to initialize GeoIP service: %v", err)
+		return 1
+	}
+	defer service.Close()
+	lookupService := withCache(cfg, service)
+
+	for i, name := range inputs {
+		input := stdin
+		if name != "-" {
+			file, err := os.Open(name)
+			if err != nil {
+				log.Printf("Failed to open input: %v", err)
+				return 1
+			}
+			defer file.Close()
+			input = file
+		}
+
+		// The CSV header is only written once for all inputs
+		if err := enrich(lookupService, options, input, stdout, i == 0); err != nil {
+			log.Printf("Failed to enrich %s: %v", name, err)
+			return 1
+		}
+	}
+
+	return 0
+}
+
+// enrich copies the records of input to output, adding their country. The
+// lookups run on multiple workers, the records are written in input order.
+func enrich(service geoip.GeoIPService, options *enrichOptions, input io.Reader, output io.Writer, writeHeader bool) error {
+	e := newEnricher(options, input, output, writeHeader)
+	lookup := func(value string) *geoip.CountryInfo {
+		return lookupCountry(service, value)
+	}
+
+	jobs := make(chan *enrichJob, options.workers)
+	ordered := make(chan *enrichJob, options.workers*16)
+	readErr := make(chan error, 1)
+	stop := make(chan struct{})
+	defer close(stop)
+
+	go func() {
+		defer close(ordered)
+		defer close(jobs)
+
+		for {
+			job, err := e.read()
+			if err != nil {
+				if !errors.Is(err, io.EOF) {
+					readErr <- err
+				}
+				return
+			}
+
+			select {
+			case ordered <- job:
+			case <-stop:
+				return
+			}
+			select {
+			case jobs <- job:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < options.workers; i++ {
+		go func() {
+			for job := range jobs {
+				e.enrich(job, lookup)
+				close(job.done)
+			}
+		}()
+	}
+
+	for job := range ordered {
+		<-job.done
+		if err := e.write(job); err != nil {
+			return err
+		}
+
+		// Flush once caught up with the input, so followed logs are streamed
+		if len(ordered) == 0 {
+			if err := e.flush(); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := e.flush(); err != nil {
+		return err
+	}
+	select {
+	case err := <-readErr:
+		return err
+	default:
+		return nil
+	}
+}
+
+func newEnricher(options *enrichOptions, input io.Reader, output io.Writer, writeHeader bool) enricher {
+	switch options.format {
+	case "json":
+		return &jsonEnricher{
+			lineReader: lineReader{reader: bufio.NewReader(input)},
+			lineWriter: lineWriter{writer: bufio.NewWriter(output)},
+			keys:       options.keys,
+		}
+	case "csv":
+		reader := csv.NewReader(input)
+		reader.FieldsPerRecord = -1
+		return &csvEnricher{
+			reader:      reader,
+			writer:      csv.NewWriter(output),
+			field:       options.field,
+			header:      options.header,
+			writeHeader: writeHeader,
+			column:      -1,
+		}
+	default:
+		return &textEnricher{
+			lineReader: lineReader{reader: bufio.NewReader(input)},
+			lineWriter: lineWriter{writer: bufio.NewWriter(output)},
+			pattern:    options.pattern,
+			group:      options.group,
+		}
+	}
+}
+
+// lookupCountry looks up the country of an IP, which may include a port.
+// It returns nil if the value is no IP or the lookup failed.
+func lookupCountry(service geoip.GeoIPService, value string) *geoip.CountryInfo {
+	ip := strings.TrimSpace(value)
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	if net.ParseIP(ip) == nil {
+		return nil
+	}
+
+	country, err := service.GetCountry(ip)
+	if err != nil {
+		logging.Debugf("Lookup of %s failed: %v", ip, err)
+		return nil
+	}
+	return country
+}
+
+// lineReader reads the records of the line based formats
+type lineReader struct {
+	reader *bufio.Reader
+}
+
+func (r *lineReader) read() (*enrichJob, error) {
+	line, err := r.reader.ReadBytes('\n')
+	if len(line) == 0 {
+		return nil, err
+	}
+
+	// A read error is returned again on the next call
+	job := &enrichJob{line: line, done: make(chan struct{})}
+	for _, eol := range []string{"\r\n", "\n"} {
+		if bytes.HasSuffix(line, []byte(eol)) {
+			job.line, job.eol = line[:len(line)-len(eol)], eol
+			break
+		}
+	}
+	return job, nil
+}
+
+type lineWriter struct {
+	writer *bufio.Writer
+}
+
+func (w *lineWriter) write(job *enrichJob) error {
+	if _, err := w.writer.Write(job.line); err != nil {
+		return err
+	}
+	_, err := w.writer.WriteString(job.eol)
+	return err
+}
+
+func (w *lineWriter) flush() error {
+	return w.writer.Flush()
+}
+
+// textEnricher takes the IP from a regex group and appends the country code
+// and name as quoted fields, or "-" if they are unknown, like the fields of
+// nginx and Apache access logs
+type textEnricher struct {
+	lineReader
+	lineWriter
+	pattern *regexp.Regexp
+	group   int
+}
+
+func (e *textEnricher) enrich(job *enrichJob, lookup func(ip string) *geoip.CountryInfo) {
+	code, name := "-", "-"
+	if match := e.pattern.FindSubmatchIndex(job.line); match != nil && match[2*e.group] >= 0 {
+		if country := lookup(string(job.line[match[2*e.group]:match[2*e.group+1]])); country != nil {
+			code, name = country.Code, country.Name
+		}
+	}
+
+	job.line = fmt.Appendf(job.line, " %s %s", quoteField(code), quoteField(name))
+}
+
+// quoteField quotes a value like nginx does in access logs
+func quoteField(value string) string {
+	return `"` + strings.ReplaceAll(value, `"`, `\x22`) + `"`
+}
+
+// jsonEnricher takes the IP from a key of JSON objects and adds the
+// country_code and country keys at the end of them. Lines without the key
+// are left unchanged.
+type jsonEnricher struct {
+	lineReader
+	lineWriter
+	keys []string
+}
+
+func (e *jsonEnricher) enrich(job *enrichJob, lookup func(ip string) *geoip.CountryInfo) {
+	value, ok := jsonField(job.line, e.keys)
+	if !ok {
+		return
+	}
+
+	var code, name string
+	if country := lookup(value); country != nil {
+		code, name = country.Code, country.Name
+	}
+	codeJSON, _ := json.Marshal(code)
+	nameJSON, _ := json.Marshal(name)
+
+	// The key was found, so the line is an object with at least one member
+	end := bytes.LastIndexByte(job.line, '}')
+	line := append(job.line[:end:end], `,"country_code":`...)
+	line = append(line, codeJSON...)
+	line = append(line, `,"country":`...)
+	line = append(line, nameJSON...)
+	job.line = append(line, job.line[end:]...)
+}
+
+// jsonField returns the string at the path of keys in a JSON object
+func jsonField(data []byte, keys []string) (string, bool) {
+	raw := json.RawMessage(data)
+	for _, key := range keys {
+		var object map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &object); err != nil {
+			return "", false
+		}
+		var ok bool
+		if raw, ok = object[key]; !ok {
+			return "", false
+		}
+	}
+
+	var value string
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return "", false
+	}
+	return value, true
+}
+
+// csvEnricher takes the IP from a column and appends the country_code and
+// country columns
+type csvEnricher struct {
+	reader      *csv.Reader
+	writer      *csv.Writer
+	field       string
+	header      bool
+	writeHeader bool
+	column      int // index of field, -1 until the header has been read
+}
+
+func (e *csvEnricher) read() (*enrichJob, error) {
+	fields, err := e.reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	job := &enrichJob{fields: fields, done: make(chan struct{})}
+
+	if e.column < 0 {
+		if e.header {
+			job.header = true
+			e.column = slices.Index(fields, e.field)
+		}
+		if e.column < 0 {
+			number, err := strconv.Atoi(e.field)
+			if err != nil || number < 1 {
+				return nil, fmt.Errorf("column %s not found", e.field)
+			}
+			e.column = number - 1
+		}
+	}
+
+	return job, nil
+}
+
+func (e *csvEnricher) enrich(job *enrichJob, lookup func(ip string) *geoip.CountryInfo) {
+	if job.header {
+		job.fields = append(job.fields, "country_code", "country")
+		return
+	}
+
+	var code, name string
+	if e.column < len(job.fields) {
+		if country := lookup(job.fields[e.column]); country != nil {
+			code, name = country.Code, country.Name
+		}
+	}
+	job.fields = append(job.fields, code, name)
+}
+
+func (e *csvEnricher) write(job *enrichJob) error {
+	if job.header && !e.writeHeader {
+		return nil
+	}
+	return e.writer.Write(job.fields)
+}
+
+func (e *csvEnricher) flush() error {
+	e.writer.Flush()
+	return e.writer.Error()
+}
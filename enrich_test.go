@@ -0,0 +1,195 @@
+/*
+ * Copyright (C) 2025  GeorgH93
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"micro_geoip/internal/geoip"
+)
+
+func runEnrich(t *testing.T, options *enrichOptions, input string) string {
+	t.Helper()
+
+	if options.workers == 0 {
+		options.workers = 4
+	}
+	if err := options.init(); err != nil {
+		t.Fatalf("Invalid options: %v", err)
+	}
+
+	var output bytes.Buffer
+	if err := enrich(geoip.NewMockService(), options, strings.NewReader(input), &output, true); err != nil {
+		t.Fatalf("Enrich failed: %v", err)
+	}
+	return output.String()
+}
+
+func TestEnrichText(t *testing.T) {
+	input := `8.8.8.8 - - [10/Oct/2025:13:55:36 +0000] "GET / HTTP/1.1" 200 612 "-" "curl/8.0"` + "\n" +
+		`not-an-ip - - [10/Oct/2025:13:55:37 +0000] "GET / HTTP/1.1" 200 612 "-" "curl/8.0"` + "\r\n" +
+		`134.195.196.26 - - [10/Oct/2025:13:55:38 +0000] "GET / HTTP/1.1" 404 0 "-" "curl/8.0"`
+
+	expected := `8.8.8.8 - - [10/Oct/2025:13:55:36 +0000] "GET / HTTP/1.1" 200 612 "-" "curl/8.0" "US" "United States"` + "\n" +
+		`not-an-ip - - [10/Oct/2025:13:55:37 +0000] "GET / HTTP/1.1" 200 612 "-" "curl/8.0" "-" "-"` + "\r\n" +
+		`134.195.196.26 - - [10/Oct/2025:13:55:38 +0000] "GET / HTTP/1.1" 404 0 "-" "curl/8.0" "DE" "Germany"`
+
+	if output := runEnrich(t, &enrichOptions{format: "text"}, input); output != expected {
+		t.Errorf("Expected output\n%s\ngot\n%s", expected, output)
+	}
+}
+
+func TestEnrichTextNamedGroup(t *testing.T) {
+	options := &enrichOptions{format: "text", field: `client=(?P<ip>\S+)`}
+	output := runEnrich(t, options, "level=info client=[2001:4860:4860::8888]:443 status=200\n")
+
+	expected := `level=info client=[2001:4860:4860::8888]:443 status=200 "US" "United States"` + "\n"
+	if output != expected {
+		t.Errorf("Expected %q, got %q", expected, output)
+	}
+}
+
+func TestEnrichJSON(t *testing.T) {
+	options := &enrichOptions{format: "json", field: "request.client"}
+	input := `{"time":"2025-10-10T13:55:36Z","request":{"client":"8.8.8.8:51234"}}` + "\n" +
+		`{"time":"2025-10-10T13:55:37Z"}` + "\n" +
+		`not json` + "\n" +
+		`{"request":{"client":"unknown"}} ` + "\n"
+
+	expected := `{"time":"2025-10-10T13:55:36Z","request":{"client":"8.8.8.8:51234"},"country_code":"US","country":"United States"}` + "\n" +
+		`{"time":"2025-10-10T13:55:37Z"}` + "\n" +
+		`not json` + "\n" +
+		`{"request":{"client":"unknown"},"country_code":"","country":""} ` + "\n"
+
+	if output := runEnrich(t, options, input); output != expected {
+		t.Errorf("Expected output\n%s\ngot\n%s", expected, output)
+	}
+}
+
+func TestEnrichCSV(t *testing.T) {
+	options := &enrichOptions{format: "csv", field: "client", header: true}
+	input := "time,client,path\n2025-10-10,8.8.8.8,\"/a,b\"\n2025-10-11,134.195.196.26,/\n"
+
+	expected := "time,client,path,country_code,country\n2025-10-10,8.8.8.8,\"/a,b\",US,United States\n2025-10-11,134.195.196.26,/,DE,Germany\n"
+	if output := runEnrich(t, options, input); output != expected {
+		t.Errorf("Expected output\n%s\ngot\n%s", expected, output)
+	}
+}
+
+func TestEnrichCSVWithoutHeader(t *testing.T) {
+	options := &enrichOptions{format: "csv", field: "2"}
+	output := runEnrich(t, options, "2025-10-10,8.8.8.8\n2025-10-11\n")
+
+	expected := "2025-10-10,8.8.8.8,US,United States\n2025-10-11,,\n"
+	if output != expected {
+		t.Errorf("Expected %q, got %q", expected, output)
+	}
+}
+
+func TestEnrichCSVUnknownColumn(t *testing.T) {
+	options := &enrichOptions{format: "csv", field: "client", header: true, workers: 2}
+	if err := options.init(); err != nil {
+		t.Fatalf("Invalid options: %v", err)
+	}
+
+	var output bytes.Buffer
+	if err := enrich(geoip.NewMockService(), options, strings.NewReader("time,ip\n"), &output, true); err == nil {
+		t.Error("Expected error for missing column")
+	}
+}
+
+func TestEnrichKeepsOrder(t *testing.T) {
+	service := geoip.NewMockService()
+	var input, expected strings.Builder
+	for i := 0; i < 1000; i++ {
+		ip := fmt.Sprintf("10.0.%d.%d", i/256, i%256)
+		code := fmt.Sprintf("C%d", i)
+		service.SetCountry(ip, code, "Country")
+		fmt.Fprintf(&input, "%s line %d\n", ip, i)
+		fmt.Fprintf(&expected, "%s line %d \"%s\" \"Country\"\n", ip, i, code)
+	}
+
+	options := &enrichOptions{format: "text", workers: 8}
+	if err := options.init(); err != nil {
+		t.Fatalf("Invalid options: %v", err)
+	}
+
+	var output bytes.Buffer
+	if err := enrich(service, options, strings.NewReader(input.String()), &output, true); err != nil {
+		t.Fatalf("Enrich failed: %v", err)
+	}
+	if output.String() != expected.String() {
+		t.Error("Expected records in input order")
+	}
+}
+
+func TestEnrichStopsOnWriteError(t *testing.T) {
+	var input strings.Builder
+	for i := 0; i < 100000; i++ {
+		fmt.Fprintf(&input, "8.8.8.8 line %d\n", i)
+	}
+
+	options := &enrichOptions{format: "text", workers: 4}
+	if err := options.init(); err != nil {
+		t.Fatalf("Invalid options: %v", err)
+	}
+
+	// The output is closed before anything is written
+	reader, writer := io.Pipe()
+	reader.Close()
+
+	goroutines := runtime.NumGoroutine()
+	if err := enrich(geoip.NewMockService(), options, strings.NewReader(input.String()), writer, true); err == nil {
+		t.Fatal("Expected error for closed output")
+	}
+
+	// The reader and the workers must not be left behind
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > goroutines {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected pipeline goroutines to stop, %d are still running", runtime.NumGoroutine()-goroutines)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestEnrichOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		options enrichOptions
+	}{
+		{"unknown format", enrichOptions{format: "xml", workers: 1}},
+		{"invalid pattern", enrichOptions{format: "text", field: "(", workers: 1}},
+		{"CSV column name without header", enrichOptions{format: "csv", field: "ip", workers: 1}},
+		{"no workers", enrichOptions{format: "text"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.options.init(); err == nil {
+				t.Error("Expected invalid options to be rejected")
+			}
+		})
+	}
+}
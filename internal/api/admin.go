@@ -0,0 +1,187 @@
+/*
+ * Copyright (C) 2025  GeorgH93
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package api
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"micro_geoip/internal/geoip"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxUpdateJobs is the number of update jobs kept for status requests
+const maxUpdateJobs = 100
+
+type UpdateJobResponse struct {
+	ID       string     `json:"id"`
+	Status   string     `json:"status"` // "queued", "running", "succeeded" or "failed"
+	Error    string     `json:"error,omitempty"`
+	Created  time.Time  `json:"created"`
+	Started  *time.Time `json:"started,omitempty"`
+	Finished *time.Time `json:"finished,omitempty"`
+}
+
+type ReloadResponse struct {
+	Status    string                     `json:"status"` // "reloaded" or "failed"
+	Error     string                     `json:"error,omitempty"`
+	Databases []DatabaseMetadataResponse `json:"databases"`
+}
+
+// updateJobs runs update jobs one after another and keeps their status
+type updateJobs struct {
+	mu     sync.Mutex
+	run    sync.Mutex // Held while a job runs, so updates are serialized
+	jobs   map[string]*UpdateJobResponse
+	order  []string           // Job IDs, oldest first
+	queued *UpdateJobResponse // Job waiting for the running one, if any
+}
+
+func newUpdateJobs() *updateJobs {
+	return &updateJobs{jobs: make(map[string]*UpdateJobResponse)}
+}
+
+// start queues a job running update in the background. If a job is already
+// waiting to run, that one is returned instead, it will see the same state.
+func (j *updateJobs) start(update func() error) UpdateJobResponse {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.queued != nil {
+		return *j.queued
+	}
+
+	job := &UpdateJobResponse{ID: newJobID(), Status: "queued", Created: time.Now().UTC()}
+	j.jobs[job.ID] = job
+	j.order = append(j.order, job.ID)
+	if len(j.order) > maxUpdateJobs {
+		delete(j.jobs, j.order[0])
+		j.order = j.order[1:]
+	}
+	j.queued = job
+
+	go j.execute(job, update)
+	return *job
+}
+
+func (j *updateJobs) execute(job *UpdateJobResponse, update func() error) {
+	j.run.Lock()
+	defer j.run.Unlock()
+
+	j.mu.Lock()
+	started := time.Now().UTC()
+	job.Status = "running"
+	job.Started = &started
+	if j.queued == job {
+		j.queued = nil
+	}
+	j.mu.Unlock()
+
+	err := update()
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	finished := time.Now().UTC()
+	job.Finished = &finished
+	if err != nil {
+		job.Status = "failed"
+		job.Error = geoip.RedactError(err)
+	} else {
+		job.Status = "succeeded"
+	}
+}
+
+// get returns a copy of the job with the given ID
+func (j *updateJobs) get(id string) (UpdateJobResponse, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	job, ok := j.jobs[id]
+	if !ok {
+		return UpdateJobResponse{}, false
+	}
+	return *job, true
+}
+
+func newJobID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// requireAdminToken rejects requests without the configured admin token as
+// bearer token. Without a configured token the endpoints don't exist.
+func (s *Server) requireAdminToken(c *gin.Context) {
+	adminToken := s.currentConfig().Security.AdminToken
+	if adminToken == "" {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+		c.Header("WWW-Authenticate", `Bearer realm="admin"`)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid or missing admin token"})
+		return
+	}
+
+	c.Next()
+}
+
+// startUpdate starts a database update in the background
+func (s *Server) startUpdate(c *gin.Context) {
+	job := s.updateJobs.start(s.geoipService.Update)
+	c.Header("Location", "/admin/update/"+job.ID)
+	c.JSON(http.StatusAccepted, job)
+}
+
+func (s *Server) updateStatus(c *gin.Context) {
+	job, ok := s.updateJobs.get(c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown update job"})
+		return
+	}
+
+	c.JSON(http.StatusOK, job)
+}
+
+// reload re-opens the databases from disk
+func (s *Server) reload(c *gin.Context) {
+	err := s.geoipService.Reload()
+
+	response := ReloadResponse{Status: "reloaded", Databases: []DatabaseMetadataResponse{}}
+	for _, status := range s.geoipService.Status() {
+		response.Databases = append(response.Databases, databaseMetadataResponse(status))
+	}
+
+	if err != nil {
+		response.Status = "failed"
+		response.Error = geoip.RedactError(err)
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,224 @@
+/*
+ * Copyright (C) 2025  GeorgH93
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"micro_geoip/internal/config"
+	"micro_geoip/internal/geoip"
+)
+
+const testAdminToken = "admin-secret"
+
+// withAdminToken enables the admin endpoints of a test server
+func withAdminToken(cfg *config.Config) {
+	cfg.Security.AdminToken = testAdminToken
+}
+
+func adminRequest(t *testing.T, server *Server, method, path string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req, err := http.NewRequest(method, path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+testAdminToken)
+
+	return serveRequest(server, req)
+}
+
+// waitForJob polls the job until it is finished
+func waitForJob(t *testing.T, server *Server, id string) UpdateJobResponse {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		rr := adminRequest(t, server, "GET", "/admin/update/"+id)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status OK for job %s, got %d", id, rr.Code)
+		}
+
+		var job UpdateJobResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &job); err != nil {
+			t.Fatal("Failed to parse JSON response")
+		}
+		if job.Finished != nil {
+			return job
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Job %s did not finish, last status %s", id, job.Status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func startUpdateJob(t *testing.T, server *Server) UpdateJobResponse {
+	t.Helper()
+
+	rr := adminRequest(t, server, "POST", "/admin/update")
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("Expected status %d, got %d", http.StatusAccepted, rr.Code)
+	}
+
+	var job UpdateJobResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &job); err != nil {
+		t.Fatal("Failed to parse JSON response")
+	}
+	if job.ID == "" || rr.Header().Get("Location") != "/admin/update/"+job.ID {
+		t.Fatalf("Expected job ID and location, got %+v (%s)", job, rr.Header().Get("Location"))
+	}
+	return job
+}
+
+func TestAdminDisabledWithoutToken(t *testing.T) {
+	server := createTestServer(t)
+
+	rr := adminRequest(t, server, "POST", "/admin/reload")
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected admin endpoints to be disabled without token, got %d", rr.Code)
+	}
+}
+
+func TestAdminAuthentication(t *testing.T) {
+	server, _ := createMockTestServer(t, withAdminToken)
+
+	for _, authorization := range []string{"", "Bearer wrong", testAdminToken, "Basic " + testAdminToken} {
+		req, _ := http.NewRequest("POST", "/admin/reload", nil)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+
+		rr := serveRequest(server, req)
+		if rr.Code != http.StatusUnauthorized {
+			t.Errorf("Authorization %q: expected status %d, got %d", authorization, http.StatusUnauthorized, rr.Code)
+		}
+		if rr.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("Authorization %q: expected WWW-Authenticate header", authorization)
+		}
+	}
+}
+
+func TestAdminUpdate(t *testing.T) {
+	server, geoipService := createMockTestServer(t, withAdminToken)
+
+	job := waitForJob(t, server, startUpdateJob(t, server).ID)
+	if job.Status != "succeeded" || job.Started == nil || geoipService.Updates != 1 {
+		t.Errorf("Expected one succeeded update, got %+v after %d updates", job, geoipService.Updates)
+	}
+
+	geoipService.UpdateErr = fmt.Errorf("country database update failed: %w", &url.Error{
+		Op:  "Get",
+		URL: "https://download.maxmind.com/app/geoip_download?license_key=secret",
+		Err: errors.New("connection refused"),
+	})
+	job = waitForJob(t, server, startUpdateJob(t, server).ID)
+	if job.Status != "failed" || !strings.Contains(job.Error, "connection refused") {
+		t.Errorf("Expected failed update, got %+v", job)
+	}
+	if strings.Contains(job.Error, "secret") {
+		t.Errorf("Expected license key to be redacted, got %s", job.Error)
+	}
+
+	if rr := adminRequest(t, server, "GET", "/admin/update/unknown"); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d for unknown job, got %d", http.StatusNotFound, rr.Code)
+	}
+}
+
+// blockingUpdateService blocks updates until released and records how many
+// run at the same time
+type blockingUpdateService struct {
+	*geoip.MockService
+	release       chan struct{}
+	running       atomic.Int32
+	maxConcurrent atomic.Int32
+	updates       atomic.Int32
+}
+
+func (b *blockingUpdateService) Update() error {
+	running := b.running.Add(1)
+	defer b.running.Add(-1)
+	if running > b.maxConcurrent.Load() {
+		b.maxConcurrent.Store(running)
+	}
+
+	b.updates.Add(1)
+	<-b.release
+	return nil
+}
+
+func TestAdminUpdateSerialized(t *testing.T) {
+	server, mock := createMockTestServer(t, withAdminToken)
+	geoipService := &blockingUpdateService{MockService: mock, release: make(chan struct{})}
+	server.geoipService = geoipService
+
+	first := startUpdateJob(t, server)
+	for geoipService.updates.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// Further requests wait in a single queued job while the first one runs
+	second := startUpdateJob(t, server)
+	third := startUpdateJob(t, server)
+	if second.ID == first.ID || third.ID != second.ID || second.Status != "queued" {
+		t.Errorf("Expected one queued job behind the running one, got %+v, %+v, %+v", first, second, third)
+	}
+
+	close(geoipService.release)
+	for _, id := range []string{first.ID, second.ID} {
+		if job := waitForJob(t, server, id); job.Status != "succeeded" {
+			t.Errorf("Expected job %s to succeed, got %+v", id, job)
+		}
+	}
+
+	if updates, concurrent := geoipService.updates.Load(), geoipService.maxConcurrent.Load(); updates != 2 || concurrent != 1 {
+		t.Errorf("Expected 2 serialized updates, got %d with up to %d at once", updates, concurrent)
+	}
+}
+
+func TestAdminReload(t *testing.T) {
+	server, geoipService := createMockTestServer(t, withAdminToken)
+
+	rr := adminRequest(t, server, "POST", "/admin/reload")
+	if rr.Code != http.StatusOK || geoipService.Reloads != 1 {
+		t.Errorf("Expected successful reload, got %d after %d reloads", rr.Code, geoipService.Reloads)
+	}
+
+	var response ReloadResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatal("Failed to parse JSON response")
+	}
+	if response.Status != "reloaded" || len(response.Databases) != 1 || !response.Databases[0].Loaded {
+		t.Errorf("Unexpected reload response: %+v", response)
+	}
+
+	geoipService.ReloadErr = errors.New("failed to reload country database: database file does not exist")
+	rr = adminRequest(t, server, "POST", "/admin/reload")
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("Expected status %d for failed reload, got %d", http.StatusInternalServerError, rr.Code)
+	}
+}
@@ -0,0 +1,223 @@
+/*
+ * Copyright (C) 2025  GeorgH93
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+)
+
+// errBatchTooLarge is returned when a batch contains more IPs than allowed
+var errBatchTooLarge = errors.New("too many IP addresses")
+
+// batchLookup looks up all IPs of the request body. The body is either a
+// JSON array or one IP per line, as plain text or NDJSON. Results are
+// returned as JSON array, or streamed as NDJSON if the client asks for it.
+func (s *Server) batchLookup(c *gin.Context) {
+	// Batches would allow looking up arbitrary IPs
+	if s.blockIPParam() {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Batch lookups are disabled"})
+		return
+	}
+
+	// Every lookup would fail without the country database
+	if !s.countryDatabaseLoaded() {
+		setRetryAfter(c)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "GeoIP database not available yet"})
+		return
+	}
+
+	limit := s.currentConfig().Server.MaxBatchSize
+	if limit <= 0 && wantsNDJSON(c) {
+		s.streamBatchLookup(c)
+		return
+	}
+
+	// Read the whole batch first, so an oversized one is rejected before any lookup
+	var ips []string
+	err := readBatch(c.Request.Body, limit, func(ip string) error {
+		ips = append(ips, ip)
+		return nil
+	})
+	if errors.Is(err, errBatchTooLarge) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+			"error": fmt.Sprintf("Batch exceeds the maximum of %d IP addresses", limit),
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid batch: %v", err)})
+		return
+	}
+
+	if wantsNDJSON(c) {
+		c.Header("Content-Type", "application/x-ndjson")
+		s.setDatabaseHeaders(c)
+		c.Status(http.StatusOK)
+
+		encoder := json.NewEncoder(c.Writer)
+		for _, ip := range ips {
+			response, _ := s.lookup(ip)
+			if err := encoder.Encode(response); err != nil {
+				return
+			}
+		}
+		return
+	}
+
+	responses := make([]GeoResponse, 0, len(ips))
+	for _, ip := range ips {
+		response, _ := s.lookup(ip)
+		responses = append(responses, response)
+	}
+
+	s.setDatabaseHeaders(c)
+	c.JSON(http.StatusOK, responses)
+}
+
+// streamBatchLookup writes one result line per IP while the body is still
+// being read, so batches without a size limit don't have to be kept in memory
+func (s *Server) streamBatchLookup(c *gin.Context) {
+	c.Header("Content-Type", "application/x-ndjson")
+	s.setDatabaseHeaders(c)
+	c.Status(http.StatusOK)
+
+	encoder := json.NewEncoder(c.Writer)
+	err := readBatch(c.Request.Body, 0, func(ip string) error {
+		response, _ := s.lookup(ip)
+		return encoder.Encode(response)
+	})
+
+	// The status has already been sent, report the error as last line
+	if err != nil {
+		encoder.Encode(gin.H{"error": fmt.Sprintf("Invalid batch: %v", err)})
+	}
+}
+
+// wantsNDJSON reports whether the client asked for streamed NDJSON results
+func wantsNDJSON(c *gin.Context) bool {
+	return c.Query("format") == "ndjson" || strings.Contains(c.GetHeader("Accept"), "application/x-ndjson")
+}
+
+// readBatch calls fn for each IP in body. It returns errBatchTooLarge once
+// more than limit IPs have been read, 0 means no limit.
+func readBatch(body io.Reader, limit int, fn func(ip string) error) error {
+	count := 0
+	add := func(ip string) error {
+		count++
+		if limit > 0 && count > limit {
+			return errBatchTooLarge
+		}
+		return fn(ip)
+	}
+
+	reader := bufio.NewReader(body)
+	first, err := skipSpace(reader)
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if first == '[' {
+		return readJSONBatch(reader, add)
+	}
+	return readLineBatch(reader, add)
+}
+
+// readJSONBatch reads a JSON array of IP strings. Items that aren't strings
+// are passed on as is, so they are reported as invalid IPs.
+func readJSONBatch(r io.Reader, add func(ip string) error) error {
+	decoder := json.NewDecoder(r)
+	if _, err := decoder.Token(); err != nil {
+		return err
+	}
+
+	for decoder.More() {
+		var raw json.RawMessage
+		if err := decoder.Decode(&raw); err != nil {
+			return err
+		}
+
+		var ip string
+		if err := json.Unmarshal(raw, &ip); err != nil {
+			ip = string(raw)
+		}
+		if err := add(ip); err != nil {
+			return err
+		}
+	}
+
+	_, err := decoder.Token()
+	return err
+}
+
+// readLineBatch reads one IP per line. Lines may also be NDJSON, either a
+// JSON string or an object with an "ip" field.
+func readLineBatch(r io.Reader, add func(ip string) error) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		// Lines that can't be decoded are used as is and reported as invalid IPs
+		ip := line
+		switch line[0] {
+		case '"':
+			json.Unmarshal([]byte(line), &ip)
+		case '{':
+			var item struct {
+				IP string `json:"ip"`
+			}
+			if err := json.Unmarshal([]byte(line), &item); err == nil {
+				ip = item.IP
+			}
+		}
+
+		if err := add(ip); err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
+
+// skipSpace skips leading whitespace and returns the first other byte
+// without consuming it
+func skipSpace(r *bufio.Reader) (byte, error) {
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if !unicode.IsSpace(rune(b)) {
+			return b, r.UnreadByte()
+		}
+	}
+}
@@ -0,0 +1,191 @@
+/*
+ * Copyright (C) 2025  GeorgH93
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"micro_geoip/internal/config"
+	"micro_geoip/internal/geoip"
+)
+
+func postBatch(t *testing.T, server *Server, path, contentType, body string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req, err := http.NewRequest("POST", path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", contentType)
+
+	rr := httptest.NewRecorder()
+	server.router.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestBatchLookup(t *testing.T) {
+	server := createTestServer(t)
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+	}{
+		{"json array", "application/json", `["8.8.8.8", "invalid-ip", 42, "134.195.196.26"]`},
+		{"plain text", "text/plain", "8.8.8.8\ninvalid-ip\r\n\n42\n134.195.196.26\n"},
+		{"ndjson", "application/x-ndjson", "\"8.8.8.8\"\n{\"ip\": \"invalid-ip\"}\n42\n{\"ip\": \"134.195.196.26\"}\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := postBatch(t, server, "/geoip/batch", tt.contentType, tt.body)
+			if rr.Code != http.StatusOK {
+				t.Fatalf("Expected status OK, got %d: %s", rr.Code, rr.Body.String())
+			}
+
+			var responses []GeoResponse
+			if err := json.Unmarshal(rr.Body.Bytes(), &responses); err != nil {
+				t.Fatalf("Failed to parse JSON response: %v", err)
+			}
+
+			if len(responses) != 4 {
+				t.Fatalf("Expected 4 results, got %d", len(responses))
+			}
+			if responses[0].CountryCode != "US" || responses[0].Error != "" {
+				t.Errorf("Expected US for 8.8.8.8, got %+v", responses[0])
+			}
+			if responses[1].IP != "invalid-ip" || responses[1].Error != "Invalid IP address" {
+				t.Errorf("Expected per-item error for invalid-ip, got %+v", responses[1])
+			}
+			if responses[2].IP != "42" || responses[2].Error == "" {
+				t.Errorf("Expected per-item error for 42, got %+v", responses[2])
+			}
+			if responses[3].CountryCode != "DE" {
+				t.Errorf("Expected DE for 134.195.196.26, got %+v", responses[3])
+			}
+		})
+	}
+}
+
+func TestBatchLookupLimits(t *testing.T) {
+	server := createTestServer(t)
+	server.config.Server.MaxBatchSize = 2
+
+	rr := postBatch(t, server, "/geoip/batch", "application/json", `["8.8.8.8", "1.1.1.1"]`)
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected status OK at the limit, got %d", rr.Code)
+	}
+
+	rr = postBatch(t, server, "/geoip/batch", "application/json", `["8.8.8.8", "1.1.1.1", "8.8.4.4"]`)
+	if rr.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected status %d above the limit, got %d", http.StatusRequestEntityTooLarge, rr.Code)
+	}
+
+	rr = postBatch(t, server, "/geoip/batch", "application/json", `["8.8.8.8", `)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d for malformed JSON, got %d", http.StatusBadRequest, rr.Code)
+	}
+
+	server.config.Security.BlockIPParam = true
+	rr = postBatch(t, server, "/geoip/batch", "application/json", `["8.8.8.8"]`)
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("Expected status %d with blocked IP parameter, got %d", http.StatusForbidden, rr.Code)
+	}
+}
+
+func TestBatchLookupWithoutDatabase(t *testing.T) {
+	geoipService := geoip.NewMockService()
+	geoipService.Unavailable = true
+	server := NewServer(&config.Config{}, geoipService)
+
+	rr := postBatch(t, server, "/geoip/batch", "application/json", `["8.8.8.8"]`)
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status %d without database, got %d", http.StatusServiceUnavailable, rr.Code)
+	}
+	if rr.Header().Get("Retry-After") == "" {
+		t.Error("Expected Retry-After header without database")
+	}
+}
+
+func TestBatchLookupStream(t *testing.T) {
+	for _, limit := range []int{0, 3} {
+		server := createTestServer(t)
+		server.config.Server.MaxBatchSize = limit
+
+		rr := postBatch(t, server, "/geoip/batch?format=ndjson", "text/plain", "8.8.8.8\ninvalid-ip\n134.195.196.26\n")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Limit %d: expected status OK, got %d", limit, rr.Code)
+		}
+		if contentType := rr.Header().Get("Content-Type"); contentType != "application/x-ndjson" {
+			t.Errorf("Limit %d: expected NDJSON content type, got %s", limit, contentType)
+		}
+
+		var lines []GeoResponse
+		scanner := bufio.NewScanner(rr.Body)
+		for scanner.Scan() {
+			var response GeoResponse
+			if err := json.Unmarshal(scanner.Bytes(), &response); err != nil {
+				t.Fatalf("Failed to parse NDJSON line %q: %v", scanner.Text(), err)
+			}
+			lines = append(lines, response)
+		}
+
+		if len(lines) != 3 {
+			t.Fatalf("Limit %d: expected 3 lines, got %d", limit, len(lines))
+		}
+		if lines[0].CountryCode != "US" || lines[1].Error == "" || lines[2].CountryCode != "DE" {
+			t.Errorf("Limit %d: unexpected results: %+v", limit, lines)
+		}
+	}
+}
+
+// countingService counts the country lookups of the mock service
+type countingService struct {
+	*geoip.MockService
+	lookups atomic.Int32
+}
+
+func (c *countingService) GetCountry(ip string) (*geoip.CountryInfo, error) {
+	c.lookups.Add(1)
+	return c.MockService.GetCountry(ip)
+}
+
+func TestBatchLookupTooLargeWithoutLookups(t *testing.T) {
+	server, mock := createMockTestServer(t, func(cfg *config.Config) {
+		cfg.Server.MaxBatchSize = 3
+	})
+	geoipService := &countingService{MockService: mock}
+	server.geoipService = geoipService
+
+	for _, path := range []string{"/geoip/batch", "/geoip/batch?format=ndjson"} {
+		rr := postBatch(t, server, path, "text/plain", "8.8.8.8\ninvalid-ip\n134.195.196.26\n1.1.1.1\n")
+		if rr.Code != http.StatusRequestEntityTooLarge || !strings.Contains(rr.Body.String(), "maximum of 3") {
+			t.Errorf("%s: expected status %d above the limit, got %d: %s", path, http.StatusRequestEntityTooLarge, rr.Code, rr.Body.String())
+		}
+	}
+
+	if lookups := geoipService.lookups.Load(); lookups != 0 {
+		t.Errorf("Expected no lookups for oversized batches, got %d", lookups)
+	}
+}
@@ -0,0 +1,175 @@
+/*
+ * Copyright (C) 2025  GeorgH93
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package api
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"micro_geoip/internal/logging"
+)
+
+// clientIPResolver determines the client IP of a request. Forwarding headers
+// are only honored if the request comes from a trusted proxy.
+type clientIPResolver struct {
+	trustedProxies []*net.IPNet
+	header         string // Optional header set by the proxy, e.g. CF-Connecting-IP
+}
+
+func newClientIPResolver(trustedProxies []string, header string) *clientIPResolver {
+	return &clientIPResolver{
+		trustedProxies: parseNetworks("trusted proxy", trustedProxies),
+		header:         header,
+	}
+}
+
+// parseNetworks parses a list of CIDRs or single IPs. Invalid entries are
+// logged and ignored.
+func parseNetworks(name string, entries []string) []*net.IPNet {
+	var networks []*net.IPNet
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		cidr := entry
+		if ip := net.ParseIP(entry); ip != nil && ip.To4() != nil {
+			cidr += "/32"
+		} else if ip != nil {
+			cidr += "/128"
+		}
+
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			logging.Warnf("Ignoring invalid %s '%s': %v", name, entry, err)
+			continue
+		}
+		networks = append(networks, network)
+	}
+
+	return networks
+}
+
+// resolve returns the client IP of req. The forwarding chain is walked from
+// the right, skipping trusted proxies, so the first untrusted hop is the
+// client. Entries left of it could have been set by anyone.
+func (r *clientIPResolver) resolve(req *http.Request) string {
+	remoteIP, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		remoteIP = req.RemoteAddr
+	}
+
+	// Headers from untrusted peers can't be told apart from spoofed ones
+	if !containsIP(r.trustedProxies, net.ParseIP(remoteIP)) {
+		return remoteIP
+	}
+
+	if r.header != "" {
+		if ip := strings.TrimSpace(req.Header.Get(r.header)); net.ParseIP(ip) != nil {
+			return ip
+		}
+	}
+
+	hops := forwardedFor(req.Header)
+	if len(hops) == 0 {
+		hops = splitHeader(req.Header.Values("X-Forwarded-For"))
+	}
+	if len(hops) == 0 {
+		if xri := strings.TrimSpace(req.Header.Get("X-Real-IP")); xri != "" {
+			hops = []string{xri}
+		}
+	}
+
+	client := remoteIP
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(hops[i])
+		if ip == nil {
+			// Obfuscated or malformed hop, nothing left of it can be trusted
+			break
+		}
+
+		client = hops[i]
+		if !containsIP(r.trustedProxies, ip) {
+			break
+		}
+	}
+
+	return client
+}
+
+// containsIP reports whether ip is part of any of the networks
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// forwardedFor returns the for= addresses of RFC 7239 Forwarded headers
+func forwardedFor(header http.Header) []string {
+	var hops []string
+	for _, element := range splitHeader(header.Values("Forwarded")) {
+		for _, pair := range strings.Split(element, ";") {
+			key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			if !ok || !strings.EqualFold(key, "for") {
+				continue
+			}
+			hops = append(hops, forwardedNode(value))
+		}
+	}
+	return hops
+}
+
+// forwardedNode strips quotes, brackets and the port from a Forwarded node,
+// e.g. "[2001:db8::17]:4711" or 192.0.2.60:8080
+func forwardedNode(node string) string {
+	node = strings.Trim(node, `"`)
+
+	if strings.HasPrefix(node, "[") {
+		if end := strings.Index(node, "]"); end > 0 {
+			return node[1:end]
+		}
+		return node
+	}
+
+	// IPv4 with port, a bare IPv6 address has more than one colon
+	if strings.Count(node, ":") == 1 {
+		host, _, _ := strings.Cut(node, ":")
+		return host
+	}
+
+	return node
+}
+
+// splitHeader splits comma separated header values into trimmed entries
+func splitHeader(values []string) []string {
+	var entries []string
+	for _, value := range values {
+		for _, entry := range strings.Split(value, ",") {
+			if entry = strings.TrimSpace(entry); entry != "" {
+				entries = append(entries, entry)
+			}
+		}
+	}
+	return entries
+}
@@ -0,0 +1,137 @@
+/*
+ * Copyright (C) 2025  GeorgH93
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package api
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestClientIPResolver(t *testing.T) {
+	resolver := newClientIPResolver([]string{"10.0.0.0/8", "192.168.1.1", "2001:db8::/32", "invalid"}, "")
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string][]string
+		expected   string
+	}{
+		{
+			name:       "no headers",
+			remoteAddr: "203.0.113.7:1234",
+			expected:   "203.0.113.7",
+		},
+		{
+			name:       "untrusted peer ignores headers",
+			remoteAddr: "203.0.113.7:1234",
+			headers:    map[string][]string{"X-Forwarded-For": {"8.8.8.8"}, "X-Real-Ip": {"8.8.8.8"}},
+			expected:   "203.0.113.7",
+		},
+		{
+			name:       "trusted peer",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string][]string{"X-Forwarded-For": {"8.8.8.8"}},
+			expected:   "8.8.8.8",
+		},
+		{
+			name:       "spoofed left entries are skipped",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string][]string{"X-Forwarded-For": {"1.2.3.4, 8.8.8.8, 10.1.1.1"}},
+			expected:   "8.8.8.8",
+		},
+		{
+			name:       "multiple headers are combined",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string][]string{"X-Forwarded-For": {"1.2.3.4", "8.8.8.8, 192.168.1.1"}},
+			expected:   "8.8.8.8",
+		},
+		{
+			name:       "all hops trusted",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string][]string{"X-Forwarded-For": {"10.2.2.2, 10.1.1.1"}},
+			expected:   "10.2.2.2",
+		},
+		{
+			name:       "malformed hop stops the walk",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string][]string{"X-Forwarded-For": {"8.8.8.8, garbage, 10.1.1.1"}},
+			expected:   "10.1.1.1",
+		},
+		{
+			name:       "x-real-ip from trusted peer",
+			remoteAddr: "[2001:db8::1]:1234",
+			headers:    map[string][]string{"X-Real-Ip": {"1.1.1.1"}},
+			expected:   "1.1.1.1",
+		},
+		{
+			name:       "forwarded header",
+			remoteAddr: "10.0.0.1:1234",
+			headers: map[string][]string{
+				"Forwarded":       {`for=1.2.3.4, for="[2001:4860:4860::8888]:4711";proto=https, For=10.1.1.1:8080;by=10.0.0.1`},
+				"X-Forwarded-For": {"9.9.9.9"},
+			},
+			expected: "2001:4860:4860::8888",
+		},
+		{
+			name:       "obfuscated forwarded node",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string][]string{"Forwarded": {"for=8.8.8.8, for=_hidden"}},
+			expected:   "10.0.0.1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for name, values := range tt.headers {
+				req.Header[name] = values
+			}
+
+			if ip := resolver.resolve(req); ip != tt.expected {
+				t.Errorf("Expected client IP %s, got %s", tt.expected, ip)
+			}
+		})
+	}
+}
+
+func TestClientIPResolverHeader(t *testing.T) {
+	resolver := newClientIPResolver([]string{"10.0.0.0/8"}, "CF-Connecting-IP")
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("CF-Connecting-IP", "8.8.8.8")
+	req.Header.Set("X-Forwarded-For", "1.1.1.1")
+
+	if ip := resolver.resolve(req); ip != "8.8.8.8" {
+		t.Errorf("Expected client IP from configured header, got %s", ip)
+	}
+
+	// Without the header the forwarding chain is used
+	req.Header.Del("CF-Connecting-IP")
+	if ip := resolver.resolve(req); ip != "1.1.1.1" {
+		t.Errorf("Expected client IP from X-Forwarded-For, got %s", ip)
+	}
+
+	// The header is ignored from untrusted peers
+	req.RemoteAddr = "203.0.113.7:1234"
+	req.Header.Set("CF-Connecting-IP", "8.8.8.8")
+	if ip := resolver.resolve(req); ip != "203.0.113.7" {
+		t.Errorf("Expected remote address for untrusted peer, got %s", ip)
+	}
+}
@@ -0,0 +1,101 @@
+/*
+ * Copyright (C) 2025  GeorgH93
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"micro_geoip/internal/geoip"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CountryNetworksResponse lists the networks assigned to a country
+type CountryNetworksResponse struct {
+	CountryCode string   `json:"country_code"`
+	IPVersion   int      `json:"ip_version,omitempty"`
+	Count       int      `json:"count"`
+	Networks    []string `json:"networks"`
+	Error       string   `json:"error,omitempty"`
+}
+
+// countryNetworks lists the networks of a country, optionally only IPv4 or
+// IPv6 ones, as JSON or with format=text as one network per line
+func (s *Server) countryNetworks(c *gin.Context) {
+	code := strings.ToUpper(c.Param("code"))
+	response := CountryNetworksResponse{CountryCode: code, Networks: []string{}}
+
+	// The networks reveal the data of arbitrary IPs just like batches
+	if s.blockIPParam() {
+		response.Error = "Country network lookups are disabled"
+		c.JSON(http.StatusForbidden, response)
+		return
+	}
+
+	if len(code) != 2 || strings.Trim(code, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") != "" {
+		response.Error = "Invalid country code"
+		c.JSON(http.StatusBadRequest, response)
+		return
+	}
+
+	switch version := c.Query("ip_version"); version {
+	case "":
+	case "4", "6":
+		response.IPVersion = int(version[0] - '0')
+	default:
+		response.Error = "Invalid IP version, use 4 or 6"
+		c.JSON(http.StatusBadRequest, response)
+		return
+	}
+
+	networks, err := s.geoipService.CountryNetworks(code, response.IPVersion)
+	if errors.Is(err, geoip.ErrDatabaseUnavailable) {
+		setRetryAfter(c)
+		response.Error = "GeoIP database not available yet"
+		c.JSON(http.StatusServiceUnavailable, response)
+		return
+	}
+	if err != nil {
+		response.Error = fmt.Sprintf("Failed to collect networks: %v", err)
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+
+	for _, network := range networks {
+		response.Networks = append(response.Networks, network.String())
+	}
+	response.Count = len(response.Networks)
+
+	s.setDatabaseHeaders(c)
+	if c.Query("format") == "text" {
+		c.String(http.StatusOK, "%s", textLines(response.Networks))
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// textLines joins values to newline terminated lines
+func textLines(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return strings.Join(values, "\n") + "\n"
+}
@@ -0,0 +1,99 @@
+/*
+ * Copyright (C) 2025  GeorgH93
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"micro_geoip/internal/config"
+	"micro_geoip/internal/geoip"
+)
+
+// setTestCountryNetworks gives the mock service networks for US
+func setTestCountryNetworks(geoipService *geoip.MockService) {
+	geoipService.NetworkMap = map[string][]netip.Prefix{
+		"US": {
+			netip.MustParsePrefix("8.8.0.0/16"),
+			netip.MustParsePrefix("2001:4860::/32"),
+		},
+	}
+}
+
+func TestCountryNetworks(t *testing.T) {
+	server, geoipService := createMockTestServer(t, nil)
+	setTestCountryNetworks(geoipService)
+
+	tests := []struct {
+		path     string
+		networks []string
+	}{
+		{"/country/US/networks", []string{"8.8.0.0/16", "2001:4860::/32"}},
+		{"/country/us/networks?ip_version=4", []string{"8.8.0.0/16"}},
+		{"/country/US/networks?ip_version=6", []string{"2001:4860::/32"}},
+		{"/country/DE/networks", []string{}},
+	}
+
+	for _, tt := range tests {
+		var response CountryNetworksResponse
+		rr := getJSON(t, server, tt.path, &response)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200 for %s, got %d: %s", tt.path, rr.Code, rr.Body.String())
+		}
+		if response.Count != len(tt.networks) || len(response.Networks) != len(tt.networks) {
+			t.Fatalf("Expected %d networks for %s, got %+v", len(tt.networks), tt.path, response)
+		}
+		for i, network := range tt.networks {
+			if response.Networks[i] != network {
+				t.Errorf("Expected network %s for %s, got %s", network, tt.path, response.Networks[i])
+			}
+		}
+	}
+
+	rr := serveRequest(server, httptest.NewRequest(http.MethodGet, "/country/US/networks?format=text", nil))
+	if rr.Code != http.StatusOK || rr.Body.String() != "8.8.0.0/16\n2001:4860::/32\n" {
+		t.Errorf("Expected one network per line, got %d: %q", rr.Code, rr.Body.String())
+	}
+}
+
+func TestCountryNetworksErrors(t *testing.T) {
+	server, geoipService := createMockTestServer(t, nil)
+	setTestCountryNetworks(geoipService)
+
+	var response CountryNetworksResponse
+	for _, path := range []string{"/country/USA/networks", "/country/1A/networks", "/country/US/networks?ip_version=5"} {
+		if rr := getJSON(t, server, path, &response); rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for %s, got %d", path, rr.Code)
+		}
+	}
+
+	geoipService.Unavailable = true
+	rr := getJSON(t, server, "/country/US/networks", &response)
+	if rr.Code != http.StatusServiceUnavailable || rr.Header().Get("Retry-After") == "" {
+		t.Errorf("Expected status 503 with Retry-After without database, got %d", rr.Code)
+	}
+
+	blocked, _ := createMockTestServer(t, func(cfg *config.Config) {
+		cfg.Security.BlockIPParam = true
+	})
+	if rr := getJSON(t, blocked, "/country/US/networks", &response); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403 with block_ip_param, got %d", rr.Code)
+	}
+}
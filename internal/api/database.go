@@ -0,0 +1,107 @@
+/*
+ * Copyright (C) 2025  GeorgH93
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"micro_geoip/internal/geoip"
+
+	"github.com/gin-gonic/gin"
+)
+
+// databaseBuildHeader is added to lookups once for each loaded database
+const databaseBuildHeader = "X-GeoIP-DB-Build"
+
+type DatabaseResponse struct {
+	Databases []DatabaseMetadataResponse `json:"databases"`
+}
+
+type DatabaseMetadataResponse struct {
+	Name        string            `json:"name"`
+	Loaded      bool              `json:"loaded"`
+	Type        string            `json:"type,omitempty"`
+	BuildEpoch  int64             `json:"build_epoch,omitempty"`
+	BuildTime   *time.Time        `json:"build_time,omitempty"`
+	IPVersion   uint              `json:"ip_version,omitempty"`
+	Languages   []string          `json:"languages,omitempty"`
+	NodeCount   uint              `json:"node_count,omitempty"`
+	Description map[string]string `json:"description,omitempty"`
+	Provider    string            `json:"provider,omitempty"`
+	SHA256      string            `json:"sha256,omitempty"`
+	Path        string            `json:"path"`
+}
+
+// databaseInfo returns the metadata of all configured databases, the country
+// database first
+func (s *Server) databaseInfo(c *gin.Context) {
+	response := DatabaseResponse{Databases: []DatabaseMetadataResponse{}}
+	for _, status := range s.geoipService.Status() {
+		response.Databases = append(response.Databases, databaseMetadataResponse(status))
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// setDatabaseHeaders tells which databases answered a lookup, so results can
+// be traced back to a database release
+func (s *Server) setDatabaseHeaders(c *gin.Context) {
+	for _, status := range s.geoipService.Status() {
+		if status.Loaded {
+			c.Writer.Header().Add(databaseBuildHeader, databaseBuild(status))
+		}
+	}
+}
+
+// databaseBuild describes a loaded database in a single header value, e.g.
+// "country; type=GeoLite2-Country; build=1735689600; provider=DB-IP; sha256=..."
+func databaseBuild(status geoip.DatabaseStatus) string {
+	build := fmt.Sprintf("%s; type=%s; build=%d", status.Name, status.Type, status.BuildTime.Unix())
+	if status.Source != "" {
+		build += "; provider=" + status.Source
+	}
+	if status.Checksum != "" {
+		build += "; sha256=" + status.Checksum
+	}
+	return build
+}
+
+func databaseMetadataResponse(status geoip.DatabaseStatus) DatabaseMetadataResponse {
+	response := DatabaseMetadataResponse{
+		Name:     status.Name,
+		Loaded:   status.Loaded,
+		Type:     status.Type,
+		Provider: status.Source,
+		Path:     status.Path,
+	}
+
+	if status.Loaded {
+		buildTime := status.BuildTime.UTC()
+		response.BuildEpoch = buildTime.Unix()
+		response.BuildTime = &buildTime
+		response.IPVersion = status.IPVersion
+		response.Languages = status.Languages
+		response.NodeCount = status.NodeCount
+		response.Description = status.Description
+		response.SHA256 = status.Checksum
+	}
+
+	return response
+}
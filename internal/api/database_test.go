@@ -0,0 +1,100 @@
+/*
+ * Copyright (C) 2025  GeorgH93
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package api
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"micro_geoip/internal/geoip"
+)
+
+// setTestDatabaseStatuses makes the mock report a loaded country database
+// with full metadata and a city database that isn't loaded
+func setTestDatabaseStatuses(geoipService *geoip.MockService) {
+	geoipService.DatabaseStatuses = []geoip.DatabaseStatus{
+		{
+			Name:        "country",
+			Loaded:      true,
+			Type:        "GeoLite2-Country",
+			BuildTime:   time.Unix(1735689600, 0),
+			Source:      "DB-IP",
+			Path:        "/data/GeoLite2-Country.mmdb",
+			IPVersion:   6,
+			Languages:   []string{"de", "en"},
+			NodeCount:   1234,
+			Description: map[string]string{"en": "GeoLite2 Country database"},
+			Checksum:    "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef",
+		},
+		{Name: "city", Path: "/data/GeoLite2-City.mmdb"},
+	}
+}
+
+func TestDatabaseInfo(t *testing.T) {
+	server, geoipService := createMockTestServer(t, nil)
+	setTestDatabaseStatuses(geoipService)
+
+	var response DatabaseResponse
+	if rr := getJSON(t, server, "/database", &response); rr.Code != http.StatusOK {
+		t.Fatalf("Expected status OK, got %d", rr.Code)
+	}
+	if len(response.Databases) != 2 {
+		t.Fatalf("Expected 2 databases, got %d", len(response.Databases))
+	}
+
+	country := response.Databases[0]
+	if !country.Loaded || country.Type != "GeoLite2-Country" || country.BuildEpoch != 1735689600 {
+		t.Errorf("Unexpected country database: %+v", country)
+	}
+	if country.IPVersion != 6 || len(country.Languages) != 2 || country.NodeCount != 1234 {
+		t.Errorf("Unexpected country metadata: %+v", country)
+	}
+	if country.Description["en"] != "GeoLite2 Country database" || country.Provider != "DB-IP" || len(country.SHA256) != 64 {
+		t.Errorf("Unexpected country details: %+v", country)
+	}
+
+	city := response.Databases[1]
+	if city.Loaded || city.BuildTime != nil || city.SHA256 != "" {
+		t.Errorf("Expected no metadata for unloaded city database, got %+v", city)
+	}
+}
+
+func TestDatabaseBuildHeader(t *testing.T) {
+	server, geoipService := createMockTestServer(t, nil)
+	setTestDatabaseStatuses(geoipService)
+
+	for _, path := range []string{"/geoip/8.8.8.8", "/geoip/invalid-ip"} {
+		var response GeoResponse
+		rr := getJSON(t, server, path, &response)
+
+		headers := rr.Header().Values(databaseBuildHeader)
+		if rr.Code != http.StatusOK {
+			if len(headers) != 0 {
+				t.Errorf("%s: expected no %s header on failed lookup, got %v", path, databaseBuildHeader, headers)
+			}
+			continue
+		}
+
+		// Only loaded databases are listed
+		expected := "country; type=GeoLite2-Country; build=1735689600; provider=DB-IP; sha256=0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+		if len(headers) != 1 || headers[0] != expected {
+			t.Errorf("%s: expected %s header %q, got %v", path, databaseBuildHeader, expected, headers)
+		}
+	}
+}
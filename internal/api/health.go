@@ -0,0 +1,147 @@
+/*
+ * Copyright (C) 2025  GeorgH93
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"micro_geoip/internal/geoip"
+	"micro_geoip/internal/logging"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ReadinessResponse struct {
+	Status    string                   `json:"status"` // "ready" or "not ready"
+	Reason    string                   `json:"reason,omitempty"`
+	Databases []DatabaseStatusResponse `json:"databases"`
+}
+
+type DatabaseStatusResponse struct {
+	Name       string                `json:"name"`
+	Loaded     bool                  `json:"loaded"`
+	Type       string                `json:"type,omitempty"`
+	BuildTime  *time.Time            `json:"build_time,omitempty"`
+	AgeSeconds int64                 `json:"age_seconds,omitempty"`
+	Source     string                `json:"source,omitempty"`
+	Path       string                `json:"path"`
+	LastUpdate *UpdateStatusResponse `json:"last_update,omitempty"`
+}
+
+type UpdateStatusResponse struct {
+	Time     time.Time `json:"time"`
+	Provider string    `json:"provider"`
+	Result   string    `json:"result"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// liveness reports that the process is up, independent of the database state
+func (s *Server) liveness(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// readiness reports whether lookups can be served: the country database must
+// be loaded, and no loaded database may be older than the configured maximum
+func (s *Server) readiness(c *gin.Context) {
+	statuses := s.geoipService.Status()
+
+	response := ReadinessResponse{Status: "ready", Databases: []DatabaseStatusResponse{}}
+	for _, status := range statuses {
+		response.Databases = append(response.Databases, databaseStatusResponse(status))
+	}
+
+	if reason := s.notReadyReason(statuses); reason != "" {
+		response.Status = "not ready"
+		response.Reason = reason
+		c.JSON(http.StatusServiceUnavailable, response)
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// notReadyReason returns why the service isn't ready, or "" if it is
+func (s *Server) notReadyReason(statuses []geoip.DatabaseStatus) string {
+	if len(statuses) == 0 || !statuses[0].Loaded {
+		return "country database not loaded"
+	}
+
+	maxAge := s.maxDatabaseAge()
+	if maxAge <= 0 {
+		return ""
+	}
+
+	for _, status := range statuses {
+		if status.Loaded && time.Since(status.BuildTime) > maxAge {
+			return fmt.Sprintf("%s database is older than %s", status.Name, maxAge)
+		}
+	}
+
+	return ""
+}
+
+// countryDatabaseLoaded reports whether country lookups can be served
+func (s *Server) countryDatabaseLoaded() bool {
+	statuses := s.geoipService.Status()
+	return len(statuses) > 0 && statuses[0].Loaded
+}
+
+// maxDatabaseAge returns the configured maximum database age, 0 if disabled
+func (s *Server) maxDatabaseAge() time.Duration {
+	setting := s.currentConfig().GeoIP.MaxDatabaseAge
+	if setting == "" {
+		return 0
+	}
+
+	maxAge, err := time.ParseDuration(setting)
+	if err != nil {
+		logging.Warnf("Invalid max database age '%s', ignoring it: %v", setting, err)
+		return 0
+	}
+
+	return maxAge
+}
+
+func databaseStatusResponse(status geoip.DatabaseStatus) DatabaseStatusResponse {
+	response := DatabaseStatusResponse{
+		Name:   status.Name,
+		Loaded: status.Loaded,
+		Type:   status.Type,
+		Source: status.Source,
+		Path:   status.Path,
+	}
+
+	if status.Loaded {
+		buildTime := status.BuildTime.UTC()
+		response.BuildTime = &buildTime
+		response.AgeSeconds = int64(time.Since(status.BuildTime).Seconds())
+	}
+
+	if !status.LastUpdate.Time.IsZero() {
+		response.LastUpdate = &UpdateStatusResponse{
+			Time:     status.LastUpdate.Time.UTC(),
+			Provider: status.LastUpdate.Provider,
+			Result:   status.LastUpdate.Result,
+			Error:    status.LastUpdate.Error,
+		}
+	}
+
+	return response
+}
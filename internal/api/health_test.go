@@ -0,0 +1,106 @@
+/*
+ * Copyright (C) 2025  GeorgH93
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package api
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"micro_geoip/internal/config"
+	"micro_geoip/internal/geoip"
+)
+
+func TestLiveness(t *testing.T) {
+	server, geoipService := createMockTestServer(t, nil)
+	geoipService.DatabaseStatuses = []geoip.DatabaseStatus{{Name: "country"}}
+
+	// Liveness doesn't depend on the database
+	var response map[string]string
+	if rr := getJSON(t, server, "/healthz", &response); rr.Code != http.StatusOK {
+		t.Errorf("Expected status OK, got %d", rr.Code)
+	}
+}
+
+func TestReadiness(t *testing.T) {
+	buildTime := time.Now().Add(-10 * 24 * time.Hour)
+	server, geoipService := createMockTestServer(t, nil)
+	geoipService.DatabaseStatuses = []geoip.DatabaseStatus{{
+		Name:      "country",
+		Loaded:    true,
+		Type:      "GeoLite2-Country",
+		BuildTime: buildTime,
+		Source:    "MaxMind",
+		Path:      "/data/GeoLite2-Country.mmdb",
+		LastUpdate: geoip.UpdateStatus{
+			Time:     time.Now(),
+			Provider: "MaxMind",
+			Result:   "failed",
+			Error:    "MaxMind download failed with status: 500",
+		},
+	}, {
+		Name: "city",
+		Path: "/data/GeoLite2-City.mmdb",
+	}}
+
+	var response ReadinessResponse
+	if rr := getJSON(t, server, "/readyz", &response); rr.Code != http.StatusOK || response.Status != "ready" {
+		t.Fatalf("Expected ready, got %d %+v", rr.Code, response)
+	}
+
+	if len(response.Databases) != 2 {
+		t.Fatalf("Expected 2 databases, got %d", len(response.Databases))
+	}
+	country := response.Databases[0]
+	if country.Type != "GeoLite2-Country" || country.Source != "MaxMind" || country.Path != "/data/GeoLite2-Country.mmdb" {
+		t.Errorf("Unexpected database details: %+v", country)
+	}
+	if country.BuildTime == nil || country.BuildTime.Unix() != buildTime.Unix() || country.AgeSeconds < 10*24*3600 {
+		t.Errorf("Unexpected build time or age: %v, %d", country.BuildTime, country.AgeSeconds)
+	}
+	if country.LastUpdate == nil || country.LastUpdate.Result != "failed" || country.LastUpdate.Error == "" {
+		t.Errorf("Expected the failed update to be reported, got %+v", country.LastUpdate)
+	}
+
+	// An optional database that isn't loaded doesn't affect readiness
+	if response.Databases[1].Loaded || response.Databases[1].BuildTime != nil {
+		t.Errorf("Expected city database to be reported as not loaded, got %+v", response.Databases[1])
+	}
+
+	cfg := &config.Config{}
+	cfg.GeoIP.MaxDatabaseAge = "168h"
+	server.Reconfigure(cfg)
+	if rr := getJSON(t, server, "/readyz", &response); rr.Code != http.StatusServiceUnavailable || response.Status != "not ready" || response.Reason == "" {
+		t.Errorf("Expected not ready for outdated database, got %d %+v", rr.Code, response)
+	}
+
+	cfg.GeoIP.MaxDatabaseAge = "720h"
+	if rr := getJSON(t, server, "/readyz", &response); rr.Code != http.StatusOK {
+		t.Errorf("Expected ready within max age, got %d", rr.Code)
+	}
+}
+
+func TestReadinessWithoutDatabase(t *testing.T) {
+	server, geoipService := createMockTestServer(t, nil)
+	geoipService.DatabaseStatuses = []geoip.DatabaseStatus{{Name: "country", Path: "/data/GeoLite2-Country.mmdb"}}
+
+	var response ReadinessResponse
+	if rr := getJSON(t, server, "/readyz", &response); rr.Code != http.StatusServiceUnavailable || response.Reason != "country database not loaded" {
+		t.Errorf("Expected not ready without database, got %d %+v", rr.Code, response)
+	}
+}
@@ -0,0 +1,60 @@
+/*
+ * Copyright (C) 2025  GeorgH93
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	server := createTestServer(t)
+
+	for _, path := range []string{"/geoip/8.8.8.8", "/geoip/invalid-ip", "/does-not-exist"} {
+		req, _ := http.NewRequest("GET", path, nil)
+		server.router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	req, _ := http.NewRequest("GET", "/metrics", nil)
+	rr := httptest.NewRecorder()
+	server.router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status OK, got %d", rr.Code)
+	}
+
+	body := rr.Body.String()
+	for _, expected := range []string{
+		`micro_geoip_http_requests_total{method="GET",route="/geoip/:ip",status="200"}`,
+		`micro_geoip_http_requests_total{method="GET",route="/geoip/:ip",status="400"}`,
+		`micro_geoip_http_requests_total{method="GET",route="unmatched",status="404"}`,
+		`micro_geoip_http_request_duration_seconds_count{method="GET",route="/geoip/:ip",status="200"}`,
+		`micro_geoip_http_request_duration_seconds_count{method="GET",route="/geoip/:ip",status="400"}`,
+	} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("Expected metrics to contain %s", expected)
+		}
+	}
+
+	// Routes are recorded by pattern, not by the looked up IP
+	if strings.Contains(body, "8.8.8.8") {
+		t.Error("Expected no per-IP series in metrics")
+	}
+}
@@ -0,0 +1,98 @@
+/*
+ * Copyright (C) 2025  GeorgH93
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package api
+
+import (
+	"math/big"
+	"net/http"
+	"net/netip"
+
+	"github.com/gin-gonic/gin"
+)
+
+// NetworkResponse describes the network an IP belongs to, all IPs in it get
+// the same lookup response
+type NetworkResponse struct {
+	IP           string   `json:"ip"`
+	Network      string   `json:"network,omitempty"`
+	FirstAddress string   `json:"first_address,omitempty"`
+	LastAddress  string   `json:"last_address,omitempty"`
+	AddressCount *big.Int `json:"address_count,omitempty"`
+	Country      string   `json:"country,omitempty"`
+	CountryCode  string   `json:"country_code,omitempty"`
+	Error        string   `json:"error,omitempty"`
+}
+
+func (s *Server) networkLookup(c *gin.Context) {
+	targetIP := s.getClientIP(c)
+
+	// Use the IP from the path or query parameter unless it is blocked
+	if !s.blockIPParam() {
+		if ip := c.Param("ip"); ip != "" {
+			targetIP = ip
+		} else if ip := c.Query("ip"); ip != "" {
+			targetIP = ip
+		}
+	}
+
+	response, status := s.lookup(targetIP)
+	if status != http.StatusOK {
+		if status == http.StatusServiceUnavailable {
+			setRetryAfter(c)
+		}
+		c.JSON(status, NetworkResponse{IP: targetIP, Error: response.Error})
+		return
+	}
+
+	network, err := netip.ParsePrefix(response.Network)
+	if err != nil {
+		c.JSON(http.StatusNotFound, NetworkResponse{IP: targetIP, Error: "Network not available"})
+		return
+	}
+
+	first, last, count := networkRange(network)
+	s.setDatabaseHeaders(c)
+	c.JSON(http.StatusOK, NetworkResponse{
+		IP:           targetIP,
+		Network:      network.String(),
+		FirstAddress: first.String(),
+		LastAddress:  last.String(),
+		AddressCount: count,
+		Country:      response.Country,
+		CountryCode:  response.CountryCode,
+	})
+}
+
+// networkRange returns the first and last address of a network and the
+// number of addresses in it, which exceeds 64 bits for large IPv6 networks
+func networkRange(network netip.Prefix) (first, last netip.Addr, count *big.Int) {
+	network = network.Masked()
+	first = network.Addr()
+	hostBits := first.BitLen() - network.Bits()
+
+	bytes := first.AsSlice()
+	for i := len(bytes) - 1; i >= 0 && hostBits > 0; i-- {
+		bits := min(hostBits, 8)
+		bytes[i] |= byte(1<<bits - 1)
+		hostBits -= bits
+	}
+	last, _ = netip.AddrFromSlice(bytes)
+
+	count = new(big.Int).Lsh(big.NewInt(1), uint(first.BitLen()-network.Bits()))
+	return first, last, count
+}
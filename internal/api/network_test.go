@@ -0,0 +1,115 @@
+/*
+ * Copyright (C) 2025  GeorgH93
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package api
+
+import (
+	"net/http"
+	"net/netip"
+	"testing"
+
+	"micro_geoip/internal/geoip"
+)
+
+// setTestNetworks gives the IPs of the network tests a network
+func setTestNetworks(geoipService *geoip.MockService) {
+	geoipService.CountryMap["8.8.8.8"] = &geoip.CountryInfo{Code: "US", Name: "United States", Network: netip.MustParsePrefix("8.8.0.0/16")}
+	geoipService.CountryMap["2001:4860:4860::8888"] = &geoip.CountryInfo{Code: "US", Name: "United States", Network: netip.MustParsePrefix("2001:4860::/32")}
+}
+
+func TestGeoLookupNetwork(t *testing.T) {
+	server, geoipService := createMockTestServer(t, nil)
+	setTestNetworks(geoipService)
+
+	var response GeoResponse
+	getJSON(t, server, "/geoip/8.8.8.8", &response)
+	if response.Network != "8.8.0.0/16" {
+		t.Errorf("Expected network 8.8.0.0/16, got %q", response.Network)
+	}
+
+	// A more specific network of another database narrows the response
+	geoipService.ASNMap = map[string]*geoip.ASNInfo{
+		"8.8.8.8": {Number: 15169, Organization: "GOOGLE", Network: netip.MustParsePrefix("8.8.8.0/24")},
+	}
+
+	getJSON(t, server, "/geoip/8.8.8.8", &response)
+	if response.Network != "8.8.8.0/24" {
+		t.Errorf("Expected network 8.8.8.0/24, got %q", response.Network)
+	}
+}
+
+func TestNetworkLookup(t *testing.T) {
+	server, geoipService := createMockTestServer(t, nil)
+	setTestNetworks(geoipService)
+
+	tests := []struct {
+		ip                        string
+		network, first, last, cnt string
+	}{
+		{"8.8.8.8", "8.8.0.0/16", "8.8.0.0", "8.8.255.255", "65536"},
+		{"2001:4860:4860::8888", "2001:4860::/32", "2001:4860::", "2001:4860:ffff:ffff:ffff:ffff:ffff:ffff", "79228162514264337593543950336"},
+	}
+
+	for _, tt := range tests {
+		var response NetworkResponse
+		rr := getJSON(t, server, "/geoip/network/"+tt.ip, &response)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200 for %s, got %d: %+v", tt.ip, rr.Code, response)
+		}
+		if response.Network != tt.network || response.FirstAddress != tt.first || response.LastAddress != tt.last {
+			t.Errorf("Unexpected range for %s: %+v", tt.ip, response)
+		}
+		if response.AddressCount.String() != tt.cnt {
+			t.Errorf("Expected %s addresses for %s, got %s", tt.cnt, tt.ip, response.AddressCount)
+		}
+		if response.CountryCode != "US" {
+			t.Errorf("Expected country US for %s, got %s", tt.ip, response.CountryCode)
+		}
+	}
+}
+
+func TestNetworkLookupErrors(t *testing.T) {
+	server, geoipService := createMockTestServer(t, nil)
+	setTestNetworks(geoipService)
+
+	var response NetworkResponse
+	if rr := getJSON(t, server, "/geoip/network/invalid", &response); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for invalid IP, got %d", rr.Code)
+	}
+
+	// The mock has no network for this IP
+	if rr := getJSON(t, server, "/geoip/network/1.1.1.1", &response); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 without network, got %d", rr.Code)
+	}
+
+	geoipService.Unavailable = true
+	if rr := getJSON(t, server, "/geoip/network/8.8.8.8", &response); rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503 without database, got %d", rr.Code)
+	}
+}
+
+func TestNetworkRange(t *testing.T) {
+	first, last, count := networkRange(netip.MustParsePrefix("10.1.2.3/23"))
+	if first.String() != "10.1.2.0" || last.String() != "10.1.3.255" || count.Int64() != 512 {
+		t.Errorf("Unexpected range %s - %s (%s)", first, last, count)
+	}
+
+	first, last, count = networkRange(netip.MustParsePrefix("192.0.2.1/32"))
+	if first != last || count.Int64() != 1 {
+		t.Errorf("Expected single address, got %s - %s (%s)", first, last, count)
+	}
+}
@@ -0,0 +1,54 @@
+/*
+ * Copyright (C) 2025  GeorgH93
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package api
+
+import (
+	"net"
+
+	"github.com/pires/go-proxyproto"
+)
+
+// newProxyProtocolListener wraps listener to accept PROXY protocol v1 and v2
+// headers from the trusted sources, or from everyone if none are configured.
+// The address from the header replaces the remote address of the connection,
+// so it is used for caller IP lookups. Connections from other sources are
+// handled as plain HTTP and a PROXY header sent by them fails the request.
+func newProxyProtocolListener(listener net.Listener, required bool, trustedSources []string) net.Listener {
+	trusted := parseNetworks("PROXY protocol source", trustedSources)
+
+	trustedPolicy := proxyproto.USE
+	if required {
+		trustedPolicy = proxyproto.REQUIRE
+	}
+
+	return &proxyproto.Listener{
+		Listener: listener,
+		// The policy must not fail, an error would stop the server from accepting connections
+		Policy: func(upstream net.Addr) (proxyproto.Policy, error) {
+			if len(trusted) == 0 {
+				return trustedPolicy, nil
+			}
+
+			if addr, ok := upstream.(*net.TCPAddr); ok && containsIP(trusted, addr.IP) {
+				return trustedPolicy, nil
+			}
+
+			return proxyproto.SKIP, nil
+		},
+	}
+}
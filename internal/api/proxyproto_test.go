@@ -0,0 +1,160 @@
+/*
+ * Copyright (C) 2025  GeorgH93
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/pires/go-proxyproto"
+)
+
+// startProxyProtocolServer serves the test server on a local PROXY protocol listener
+func startProxyProtocolServer(t *testing.T, required bool, trustedSources []string) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+
+	server := createTestServer(t)
+	httpServer := &http.Server{Handler: server.router}
+	go httpServer.Serve(newProxyProtocolListener(listener, required, trustedSources))
+	t.Cleanup(func() { httpServer.Close() })
+
+	return listener.Addr().String()
+}
+
+// lookupOverProxyProtocol sends a caller IP lookup, preceded by header if set
+func lookupOverProxyProtocol(t *testing.T, addr string, header *proxyproto.Header) (*GeoResponse, error) {
+	t.Helper()
+
+	conn, err := net.DialTimeout("tcp", addr, time.Second)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if header != nil {
+		if _, err := header.WriteTo(conn); err != nil {
+			t.Fatalf("Failed to write PROXY header: %v", err)
+		}
+	}
+	if _, err := io.WriteString(conn, "GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n"); err != nil {
+		return nil, err
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var response GeoResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+func proxyHeader(version byte, source string) *proxyproto.Header {
+	transport := proxyproto.TCPv4
+	if net.ParseIP(source).To4() == nil {
+		transport = proxyproto.TCPv6
+	}
+
+	return &proxyproto.Header{
+		Version:           version,
+		Command:           proxyproto.PROXY,
+		TransportProtocol: transport,
+		SourceAddr:        &net.TCPAddr{IP: net.ParseIP(source), Port: 40000},
+		DestinationAddr:   &net.TCPAddr{IP: net.ParseIP(source), Port: 8080},
+	}
+}
+
+func TestProxyProtocol(t *testing.T) {
+	addr := startProxyProtocolServer(t, false, nil)
+
+	for _, version := range []byte{1, 2} {
+		response, err := lookupOverProxyProtocol(t, addr, proxyHeader(version, "134.195.196.26"))
+		if err != nil {
+			t.Fatalf("v%d lookup failed: %v", version, err)
+		}
+		if response.IP != "134.195.196.26" || response.CountryCode != "DE" {
+			t.Errorf("v%d: expected caller 134.195.196.26 in DE, got %s in %s", version, response.IP, response.CountryCode)
+		}
+	}
+
+	response, err := lookupOverProxyProtocol(t, addr, proxyHeader(2, "2001:4860:4860::8888"))
+	if err != nil {
+		t.Fatalf("IPv6 lookup failed: %v", err)
+	}
+	if response.IP != "2001:4860:4860::8888" {
+		t.Errorf("Expected IPv6 caller, got %s", response.IP)
+	}
+
+	// The header is optional unless required
+	response, err = lookupOverProxyProtocol(t, addr, nil)
+	if err != nil {
+		t.Fatalf("Lookup without PROXY header failed: %v", err)
+	}
+	if response.IP != "127.0.0.1" {
+		t.Errorf("Expected connection address without PROXY header, got %s", response.IP)
+	}
+}
+
+func TestProxyProtocolRequired(t *testing.T) {
+	addr := startProxyProtocolServer(t, true, []string{"127.0.0.0/8"})
+
+	if _, err := lookupOverProxyProtocol(t, addr, nil); err == nil {
+		t.Error("Expected connection without PROXY header to be rejected")
+	}
+
+	response, err := lookupOverProxyProtocol(t, addr, proxyHeader(1, "8.8.8.8"))
+	if err != nil {
+		t.Fatalf("Lookup with PROXY header failed: %v", err)
+	}
+	if response.IP != "8.8.8.8" {
+		t.Errorf("Expected caller 8.8.8.8, got %s", response.IP)
+	}
+}
+
+func TestProxyProtocolUntrustedSource(t *testing.T) {
+	addr := startProxyProtocolServer(t, true, []string{"10.0.0.0/8"})
+
+	// Headers from untrusted sources must not be honored
+	if response, err := lookupOverProxyProtocol(t, addr, proxyHeader(1, "8.8.8.8")); err == nil && response.IP == "8.8.8.8" {
+		t.Error("Expected PROXY header from untrusted source to be ignored")
+	}
+
+	// Plain connections from untrusted sources are served with their own address
+	response, err := lookupOverProxyProtocol(t, addr, nil)
+	if err != nil {
+		t.Fatalf("Lookup without PROXY header failed: %v", err)
+	}
+	if response.IP != "127.0.0.1" {
+		t.Errorf("Expected connection address, got %s", response.IP)
+	}
+}
@@ -18,28 +18,71 @@
 package api
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log"
 	"net"
 	"net/http"
+	"net/netip"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"micro_geoip/internal/config"
 	"micro_geoip/internal/geoip"
+	"micro_geoip/internal/logging"
 
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
+// retryAfter is suggested to clients while a database is being downloaded
+const retryAfter = 30 * time.Second
+
 type Server struct {
 	config       *config.Config
 	geoipService geoip.GeoIPService
 	router       *gin.Engine
+	httpServer   *http.Server
+	clientIP     *clientIPResolver
+	updateJobs   *updateJobs
+
+	// mu guards config, which is replaced on a configuration reload
+	mu sync.RWMutex
 }
 
 type GeoResponse struct {
 	IP          string `json:"ip"`
 	Country     string `json:"country"`
 	CountryCode string `json:"country_code"`
-	Error       string `json:"error,omitempty"`
+
+	// Network all IPs with the same response belong to, e.g. 8.8.8.0/24
+	Network string `json:"network,omitempty"`
+
+	// Location fields, only present when the city database is enabled
+	City           string   `json:"city,omitempty"`
+	Region         string   `json:"region,omitempty"`
+	RegionCode     string   `json:"region_code,omitempty"`
+	PostalCode     string   `json:"postal_code,omitempty"`
+	Latitude       *float64 `json:"latitude,omitempty"`
+	Longitude      *float64 `json:"longitude,omitempty"`
+	AccuracyRadius uint16   `json:"accuracy_radius,omitempty"`
+	TimeZone       string   `json:"time_zone,omitempty"`
+
+	// Autonomous system fields, only present when the ASN database is enabled
+	ASN   uint   `json:"asn,omitempty"`
+	ASOrg string `json:"as_org,omitempty"`
+
+	Error string `json:"error,omitempty"`
+}
+
+type ASNResponse struct {
+	IP    string `json:"ip"`
+	ASN   uint   `json:"asn"`
+	ASOrg string `json:"as_org"`
+	Error string `json:"error,omitempty"`
 }
 
 func NewServer(cfg *config.Config, geoipService geoip.GeoIPService) *Server {
@@ -49,8 +92,12 @@ func NewServer(cfg *config.Config, geoipService geoip.GeoIPService) *Server {
 		config:       cfg,
 		geoipService: geoipService,
 		router:       gin.New(),
+		clientIP:     newClientIPResolver(cfg.Security.TrustedProxies, cfg.Security.ClientIPHeader),
+		updateJobs:   newUpdateJobs(),
 	}
 
+	s.httpServer = &http.Server{Handler: s.router}
+
 	s.setupRoutes()
 	return s
 }
@@ -58,21 +105,110 @@ func NewServer(cfg *config.Config, geoipService geoip.GeoIPService) *Server {
 func (s *Server) setupRoutes() {
 	// Add basic middleware
 	s.router.Use(gin.Recovery())
-	s.router.Use(gin.Logger())
+	s.router.Use(accessLog())
+	s.router.Use(recordMetrics)
 
-	// Health check endpoint
+	// Health check endpoints
 	s.router.GET("/health", s.healthCheck)
+	s.router.GET("/healthz", s.liveness)
+	s.router.GET("/readyz", s.readiness)
+
+	// Prometheus metrics
+	s.router.GET("/metrics", gin.WrapH(promhttp.Handler()))
+
+	// Metadata of the loaded databases
+	s.router.GET("/database", s.databaseInfo)
 
 	// GeoIP lookup endpoints
 	s.router.GET("/", s.geoLookup)
 	s.router.GET("/geoip", s.geoLookup)
 	s.router.GET("/geoip/:ip", s.geoLookupWithIP)
+	s.router.GET("/geoip/network", s.networkLookup)
+	s.router.GET("/geoip/network/:ip", s.networkLookup)
+	s.router.POST("/geoip/batch", s.batchLookup)
+
+	// Networks assigned to a country
+	s.router.GET("/country/:code/networks", s.countryNetworks)
+
+	// ASN lookup endpoints
+	s.router.GET("/asn", s.asnLookup)
+	s.router.GET("/asn/:ip", s.asnLookup)
+
+	// Admin endpoints, only available with an admin token
+	admin := s.router.Group("/admin", s.requireAdminToken)
+	admin.POST("/update", s.startUpdate)
+	admin.GET("/update/:id", s.updateStatus)
+	admin.POST("/reload", s.reload)
+}
+
+// accessLog logs requests unless the log level is above info
+func accessLog() gin.HandlerFunc {
+	logger := gin.Logger()
+	return func(c *gin.Context) {
+		if logging.Enabled(logging.LevelInfo) {
+			logger(c)
+			return
+		}
+		c.Next()
+	}
+}
+
+// Reconfigure replaces the configuration of the server. Settings read per
+// request apply right away, the listener and client IP resolution keep the
+// settings they were started with.
+func (s *Server) Reconfigure(cfg *config.Config) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.config = cfg
+}
+
+// currentConfig returns the configuration last applied by Reconfigure
+func (s *Server) currentConfig() *config.Config {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.config
+}
+
+// blockIPParam reports whether lookups are restricted to the caller IP
+func (s *Server) blockIPParam() bool {
+	return s.currentConfig().Security.BlockIPParam
 }
 
 func (s *Server) Start() error {
-	addr := fmt.Sprintf("%s:%s", s.config.Server.Host, s.config.Server.Port)
+	cfg := s.currentConfig()
+	addr := fmt.Sprintf("%s:%s", cfg.Server.Host, cfg.Server.Port)
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	if proxyProtocol := cfg.Server.ProxyProtocol; proxyProtocol.Enabled {
+		listener = newProxyProtocolListener(listener, proxyProtocol.Required, proxyProtocol.TrustedSources)
+
+		policy, sources := "optional", "all"
+		if proxyProtocol.Required {
+			policy = "required"
+		}
+		if len(proxyProtocol.TrustedSources) > 0 {
+			sources = strings.Join(proxyProtocol.TrustedSources, ", ")
+		}
+		logging.Infof("PROXY protocol enabled, header %s from trusted sources: %s", policy, sources)
+	}
+
 	fmt.Printf("Starting server on %s\n", addr)
-	return s.router.Run(addr)
+	if err := s.httpServer.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Shutdown stops accepting new connections and waits for in-flight requests
+// to finish until ctx is done
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.httpServer.Shutdown(ctx)
 }
 
 func (s *Server) healthCheck(c *gin.Context) {
@@ -83,7 +219,7 @@ func (s *Server) geoLookup(c *gin.Context) {
 	var targetIP string
 
 	// Check if IP parameter is blocked
-	if s.config.Security.BlockIPParam {
+	if s.blockIPParam() {
 		targetIP = s.getClientIP(c)
 	} else {
 		// Try to get IP from query parameter
@@ -99,7 +235,7 @@ func (s *Server) geoLookup(c *gin.Context) {
 
 func (s *Server) geoLookupWithIP(c *gin.Context) {
 	// If IP parameter is blocked, ignore the path parameter and use client IP
-	if s.config.Security.BlockIPParam {
+	if s.blockIPParam() {
 		targetIP := s.getClientIP(c)
 		s.performGeoLookup(c, targetIP)
 		return
@@ -109,52 +245,163 @@ func (s *Server) geoLookupWithIP(c *gin.Context) {
 	s.performGeoLookup(c, ip)
 }
 
+func (s *Server) asnLookup(c *gin.Context) {
+	targetIP := s.getClientIP(c)
+
+	// Use the IP from the path or query parameter unless it is blocked
+	if !s.blockIPParam() {
+		if ip := c.Param("ip"); ip != "" {
+			targetIP = ip
+		} else if ip := c.Query("ip"); ip != "" {
+			targetIP = ip
+		}
+	}
+
+	if net.ParseIP(targetIP) == nil {
+		c.JSON(http.StatusBadRequest, ASNResponse{
+			IP:    targetIP,
+			Error: "Invalid IP address",
+		})
+		return
+	}
+
+	asnInfo, err := s.geoipService.GetASN(targetIP)
+	if errors.Is(err, geoip.ErrDatabaseUnavailable) {
+		// An enabled database is still being downloaded
+		if s.currentConfig().GeoIP.ASN.Enabled {
+			setRetryAfter(c)
+		}
+		c.JSON(http.StatusServiceUnavailable, ASNResponse{
+			IP:    targetIP,
+			Error: "ASN database not available",
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ASNResponse{
+			IP:    targetIP,
+			Error: fmt.Sprintf("ASN lookup failed: %v", err),
+		})
+		return
+	}
+
+	s.setDatabaseHeaders(c)
+	c.JSON(http.StatusOK, ASNResponse{
+		IP:    targetIP,
+		ASN:   asnInfo.Number,
+		ASOrg: asnInfo.Organization,
+	})
+}
+
 func (s *Server) performGeoLookup(c *gin.Context, ip string) {
+	response, status := s.lookup(ip)
+	switch status {
+	case http.StatusOK:
+		s.setDatabaseHeaders(c)
+	case http.StatusServiceUnavailable:
+		setRetryAfter(c)
+	}
+	c.JSON(status, response)
+}
+
+// setRetryAfter tells clients when to retry a lookup that failed because
+// a database is not available yet
+func setRetryAfter(c *gin.Context) {
+	c.Header("Retry-After", strconv.Itoa(int(retryAfter.Seconds())))
+}
+
+// lookup validates ip and looks it up, returning the response and its HTTP status
+func (s *Server) lookup(ip string) (GeoResponse, int) {
 	// Validate IP address
 	if net.ParseIP(ip) == nil {
-		c.JSON(http.StatusBadRequest, GeoResponse{
+		return GeoResponse{
 			IP:    ip,
 			Error: "Invalid IP address",
-		})
-		return
+		}, http.StatusBadRequest
 	}
 
 	// Perform GeoIP lookup
 	countryInfo, err := s.geoipService.GetCountry(ip)
+	if errors.Is(err, geoip.ErrDatabaseUnavailable) {
+		return GeoResponse{
+			IP:    ip,
+			Error: "GeoIP database not available yet",
+		}, http.StatusServiceUnavailable
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, GeoResponse{
+		return GeoResponse{
 			IP:    ip,
 			Error: fmt.Sprintf("GeoIP lookup failed: %v", err),
-		})
-		return
+		}, http.StatusInternalServerError
 	}
 
-	c.JSON(http.StatusOK, GeoResponse{
+	response := GeoResponse{
 		IP:          ip,
 		Country:     countryInfo.Name,
 		CountryCode: countryInfo.Code,
-	})
+	}
+	response.narrowNetwork(countryInfo.Network)
+	s.addLocation(&response, ip)
+	s.addASN(&response, ip)
+
+	return response, http.StatusOK
 }
 
-func (s *Server) getClientIP(c *gin.Context) string {
-	// Check X-Forwarded-For header
-	if xff := c.GetHeader("X-Forwarded-For"); xff != "" {
-		ips := strings.Split(xff, ",")
-		if len(ips) > 0 {
-			return strings.TrimSpace(ips[0])
+// addLocation adds city level information to the response if available.
+// The location is optional, so lookup failures don't fail the request.
+func (s *Server) addLocation(response *GeoResponse, ip string) {
+	location, err := s.geoipService.GetLocation(ip)
+	if err != nil {
+		if !errors.Is(err, geoip.ErrDatabaseUnavailable) {
+			log.Printf("Location lookup for %s failed: %v", ip, err)
 		}
+		return
 	}
 
-	// Check X-Real-IP header
-	if xri := c.GetHeader("X-Real-IP"); xri != "" {
-		return strings.TrimSpace(xri)
+	response.narrowNetwork(location.Network)
+	response.City = location.City
+	response.Region = location.Subdivision
+	response.RegionCode = location.SubdivisionCode
+	response.PostalCode = location.PostalCode
+	response.AccuracyRadius = location.AccuracyRadius
+	response.TimeZone = location.TimeZone
+
+	// Records without coordinates have them set to 0
+	if location.Latitude != 0 || location.Longitude != 0 {
+		response.Latitude = &location.Latitude
+		response.Longitude = &location.Longitude
 	}
+}
 
-	// Fall back to RemoteAddr
-	ip, _, err := net.SplitHostPort(c.Request.RemoteAddr)
+// addASN adds the autonomous system to the response if available. Like the
+// location it is optional and doesn't fail the request.
+func (s *Server) addASN(response *GeoResponse, ip string) {
+	asnInfo, err := s.geoipService.GetASN(ip)
 	if err != nil {
-		return c.Request.RemoteAddr
+		if !errors.Is(err, geoip.ErrDatabaseUnavailable) {
+			log.Printf("ASN lookup for %s failed: %v", ip, err)
+		}
+		return
 	}
 
-	return ip
+	response.narrowNetwork(asnInfo.Network)
+	response.ASN = asnInfo.Number
+	response.ASOrg = asnInfo.Organization
+}
+
+// narrowNetwork sets the network of the response to network if it is more
+// specific. Both contain the IP, so the more specific one is the range the
+// information of all databases applies to.
+func (r *GeoResponse) narrowNetwork(network netip.Prefix) {
+	if !network.IsValid() {
+		return
+	}
+	if current, err := netip.ParsePrefix(r.Network); err == nil && current.Bits() >= network.Bits() {
+		return
+	}
+	r.Network = network.String()
+}
+
+func (s *Server) getClientIP(c *gin.Context) string {
+	return s.clientIP.resolve(c.Request)
 }
@@ -18,25 +18,62 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"micro_geoip/internal/config"
 	"micro_geoip/internal/geoip"
+
+	"github.com/gin-gonic/gin"
 )
 
 func createTestServer(t *testing.T) *Server {
+	server, _ := createMockTestServer(t, nil)
+	return server
+}
+
+// createMockTestServer creates a server like createTestServer and returns the
+// mock service behind it. configure, if set, adjusts the configuration
+// before the server is created.
+func createMockTestServer(t *testing.T, configure func(cfg *config.Config)) (*Server, *geoip.MockService) {
+	t.Helper()
+
 	cfg := &config.Config{}
 	cfg.Server.Port = "8080"
 	cfg.Server.Host = "localhost"
 	cfg.Security.BlockIPParam = false
+	if configure != nil {
+		configure(cfg)
+	}
 
 	// Create a mock geoip service
 	geoipService := geoip.NewMockService()
 
-	return NewServer(cfg, geoipService)
+	return NewServer(cfg, geoipService), geoipService
+}
+
+// serveRequest sends req to the server and returns the recorded response
+func serveRequest(server *Server, req *http.Request) *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+	server.router.ServeHTTP(rr, req)
+	return rr
+}
+
+// getJSON sends a GET request for path and decodes the JSON response into
+// response
+func getJSON(t *testing.T, server *Server, path string, response any) *httptest.ResponseRecorder {
+	t.Helper()
+
+	rr := serveRequest(server, httptest.NewRequest(http.MethodGet, path, nil))
+	if err := json.Unmarshal(rr.Body.Bytes(), response); err != nil {
+		t.Fatalf("Failed to parse response %q: %v", rr.Body.String(), err)
+	}
+	return rr
 }
 
 func TestHealthCheck(t *testing.T) {
@@ -97,6 +134,167 @@ func TestGeoLookupWithValidIP(t *testing.T) {
 	}
 }
 
+func TestGeoLookupWithLocation(t *testing.T) {
+	cfg := &config.Config{}
+	geoipService := geoip.NewMockService()
+	geoipService.SetLocation("8.8.8.8", &geoip.LocationInfo{
+		CountryInfo:     geoip.CountryInfo{Code: "US", Name: "United States"},
+		City:            "Mountain View",
+		Subdivision:     "California",
+		SubdivisionCode: "US-CA",
+		PostalCode:      "94035",
+		Latitude:        37.386,
+		Longitude:       -122.0838,
+		AccuracyRadius:  1000,
+		TimeZone:        "America/Los_Angeles",
+	})
+	server := NewServer(cfg, geoipService)
+
+	req, err := http.NewRequest("GET", "/geoip/8.8.8.8", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	server.router.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("Handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	var response GeoResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatal("Failed to parse JSON response")
+	}
+
+	if response.City != "Mountain View" || response.Region != "California" || response.RegionCode != "US-CA" {
+		t.Errorf("Unexpected location: %s, %s (%s)", response.City, response.Region, response.RegionCode)
+	}
+	if response.Latitude == nil || *response.Latitude != 37.386 || response.Longitude == nil || *response.Longitude != -122.0838 {
+		t.Errorf("Unexpected coordinates: %v, %v", response.Latitude, response.Longitude)
+	}
+	if response.TimeZone != "America/Los_Angeles" || response.PostalCode != "94035" || response.AccuracyRadius != 1000 {
+		t.Errorf("Unexpected location details: %+v", response)
+	}
+}
+
+func TestGeoLookupWithoutCityDatabase(t *testing.T) {
+	server := createTestServer(t)
+
+	req, err := http.NewRequest("GET", "/geoip/8.8.8.8", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	server.router.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("Handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	var response map[string]any
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatal("Failed to parse JSON response")
+	}
+
+	for _, field := range []string{"city", "region", "latitude", "longitude", "time_zone"} {
+		if _, ok := response[field]; ok {
+			t.Errorf("Expected no %s field without city database", field)
+		}
+	}
+}
+
+func TestGeoLookupWithASN(t *testing.T) {
+	cfg := &config.Config{}
+	geoipService := geoip.NewMockService()
+	geoipService.SetASN("8.8.8.8", 15169, "GOOGLE")
+	server := NewServer(cfg, geoipService)
+
+	req, err := http.NewRequest("GET", "/geoip/8.8.8.8", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	server.router.ServeHTTP(rr, req)
+
+	var response GeoResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatal("Failed to parse JSON response")
+	}
+
+	if response.CountryCode != "US" || response.ASN != 15169 || response.ASOrg != "GOOGLE" {
+		t.Errorf("Expected US with AS15169 GOOGLE, got %s with AS%d %s", response.CountryCode, response.ASN, response.ASOrg)
+	}
+}
+
+func TestASNLookup(t *testing.T) {
+	cfg := &config.Config{}
+	geoipService := geoip.NewMockService()
+	geoipService.SetASN("1.1.1.1", 13335, "CLOUDFLARENET")
+	server := NewServer(cfg, geoipService)
+
+	tests := []struct {
+		path   string
+		status int
+		asn    uint
+	}{
+		{"/asn/1.1.1.1", http.StatusOK, 13335},
+		{"/asn?ip=1.1.1.1", http.StatusOK, 13335},
+		{"/asn/192.0.2.1", http.StatusOK, 0},
+		{"/asn/invalid-ip", http.StatusBadRequest, 0},
+	}
+
+	for _, tt := range tests {
+		req, err := http.NewRequest("GET", tt.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		server.router.ServeHTTP(rr, req)
+
+		if rr.Code != tt.status {
+			t.Errorf("%s: expected status %d, got %d", tt.path, tt.status, rr.Code)
+			continue
+		}
+
+		var response ASNResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatalf("%s: failed to parse JSON response", tt.path)
+		}
+		if response.ASN != tt.asn {
+			t.Errorf("%s: expected ASN %d, got %d", tt.path, tt.asn, response.ASN)
+		}
+	}
+}
+
+func TestASNLookupWithoutDatabase(t *testing.T) {
+	server := createTestServer(t)
+
+	req, err := http.NewRequest("GET", "/asn/8.8.8.8", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	server.router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status %d without ASN database, got %d", http.StatusServiceUnavailable, rr.Code)
+	}
+
+	// The country lookup must not be affected
+	req, _ = http.NewRequest("GET", "/geoip/8.8.8.8", nil)
+	rr = httptest.NewRecorder()
+	server.router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected status OK for country lookup, got %d", rr.Code)
+	}
+}
+
 func TestGeoLookupWithInvalidIP(t *testing.T) {
 	server := createTestServer(t)
 
@@ -122,19 +320,162 @@ func TestGeoLookupWithInvalidIP(t *testing.T) {
 	}
 }
 
+func TestGeoLookupWithoutDatabase(t *testing.T) {
+	cfg := &config.Config{}
+	geoipService := geoip.NewMockService()
+	geoipService.Unavailable = true
+	server := NewServer(cfg, geoipService)
+
+	req, err := http.NewRequest("GET", "/geoip/8.8.8.8", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	server.router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected status %d while the database is downloaded, got %d", http.StatusServiceUnavailable, rr.Code)
+	}
+	if retry := rr.Header().Get("Retry-After"); retry != "30" {
+		t.Errorf("Expected Retry-After 30, got %q", retry)
+	}
+
+	var response GeoResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatal("Failed to parse JSON response")
+	}
+	if response.Error == "" {
+		t.Error("Expected error message without database")
+	}
+
+	// Lookups work as soon as the database is available
+	geoipService.Unavailable = false
+	rr = httptest.NewRecorder()
+	server.router.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK || rr.Header().Get("Retry-After") != "" {
+		t.Errorf("Expected status OK without Retry-After, got %d", rr.Code)
+	}
+}
+
+func TestReconfigureBlockIPParam(t *testing.T) {
+	server := createTestServer(t)
+
+	lookup := func() string {
+		req, _ := http.NewRequest("GET", "/geoip/134.195.196.26", nil)
+		req.RemoteAddr = "8.8.8.8:54321"
+
+		rr := httptest.NewRecorder()
+		server.router.ServeHTTP(rr, req)
+
+		var response GeoResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatal("Failed to parse JSON response")
+		}
+		return response.IP
+	}
+
+	if ip := lookup(); ip != "134.195.196.26" {
+		t.Errorf("Expected the IP parameter to be used, got %s", ip)
+	}
+
+	cfg := &config.Config{}
+	cfg.Security.BlockIPParam = true
+	server.Reconfigure(cfg)
+
+	if ip := lookup(); ip != "8.8.8.8" {
+		t.Errorf("Expected the caller IP after blocking the IP parameter, got %s", ip)
+	}
+}
+
 func TestGetClientIP(t *testing.T) {
 	server := createTestServer(t)
+	server.clientIP = newClientIPResolver([]string{"127.0.0.1"}, "")
 
-	// Test X-Forwarded-For header
+	// Test X-Forwarded-For header from a trusted proxy
 	req, _ := http.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "127.0.0.1:54321"
 	req.Header.Set("X-Forwarded-For", "192.168.1.1, 10.0.0.1")
 
 	rr := httptest.NewRecorder()
 	server.router.ServeHTTP(rr, req)
 
-	// For this test, we'll just verify the endpoint responds
-	// since we can't easily access the getClientIP method directly
 	if rr.Code != http.StatusOK {
 		t.Errorf("Expected status OK, got %d", rr.Code)
 	}
+
+	var response GeoResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatal("Failed to parse JSON response")
+	}
+
+	// The rightmost untrusted entry is the client, the rest could be spoofed
+	if response.IP != "10.0.0.1" {
+		t.Errorf("Expected client IP 10.0.0.1, got %s", response.IP)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	// Reserve a free port for the server
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	server := createTestServer(t)
+	server.config.Server.Host, server.config.Server.Port, _ = net.SplitHostPort(addr)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	server.router.GET("/slow", func(c *gin.Context) {
+		close(started)
+		<-release
+		c.String(http.StatusOK, "done")
+	})
+
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- server.Start() }()
+
+	// Wait for the listener to be up
+	for i := 0; ; i++ {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			conn.Close()
+			break
+		}
+		if i == 100 {
+			t.Fatalf("Server did not start: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	respErr := make(chan error, 1)
+	go func() {
+		resp, err := http.Get("http://" + addr + "/slow")
+		if err == nil {
+			resp.Body.Close()
+		}
+		respErr <- err
+	}()
+	<-started
+
+	shutdownErr := make(chan error, 1)
+	go func() { shutdownErr <- server.Shutdown(context.Background()) }()
+
+	// The in-flight request is drained before Shutdown returns
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+
+	if err := <-respErr; err != nil {
+		t.Errorf("In-flight request failed during shutdown: %v", err)
+	}
+	if err := <-shutdownErr; err != nil {
+		t.Errorf("Shutdown failed: %v", err)
+	}
+	if err := <-serveErr; err != nil {
+		t.Errorf("Expected Start to return without error after shutdown, got %v", err)
+	}
 }
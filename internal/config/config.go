@@ -21,41 +21,122 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 
 	"gopkg.in/yaml.v3"
 )
 
 type Config struct {
 	Server struct {
-		Port string `yaml:"port" env:"PORT"`
-		Host string `yaml:"host" env:"HOST"`
+		Port            string `yaml:"port" env:"PORT"`
+		Host            string `yaml:"host" env:"HOST"`
+		MaxBatchSize    int    `yaml:"max_batch_size" env:"MAX_BATCH_SIZE"`
+		ShutdownTimeout string `yaml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT"`
+		LogLevel        string `yaml:"log_level" env:"LOG_LEVEL"`
+
+		ProxyProtocol struct {
+			Enabled        bool     `yaml:"enabled" env:"PROXY_PROTOCOL"`
+			Required       bool     `yaml:"required" env:"PROXY_PROTOCOL_REQUIRED"`
+			TrustedSources []string `yaml:"trusted_sources" env:"PROXY_PROTOCOL_TRUSTED_SOURCES"`
+		} `yaml:"proxy_protocol"`
 	} `yaml:"server"`
 
 	GeoIP struct {
-		MaxMindAPIKey  string `yaml:"maxmind_api_key" env:"MAXMIND_API_KEY"`
-		DatabasePath   string `yaml:"database_path" env:"GEOIP_DB_PATH"`
-		UpdateInterval string `yaml:"update_interval" env:"GEOIP_UPDATE_INTERVAL"`
-		MaxMindURL     string `yaml:"maxmind_url" env:"MAXMIND_DOWNLOAD_URL"`
-		DBIPUrl        string `yaml:"dbip_url" env:"DBIP_DOWNLOAD_URL"`
-		PreferDBIP     bool   `yaml:"prefer_dbip" env:"PREFER_DBIP"`
+		MaxMindAPIKey      string `yaml:"maxmind_api_key" env:"MAXMIND_API_KEY"`
+		DatabasePath       string `yaml:"database_path" env:"GEOIP_DB_PATH"`
+		UpdateInterval     string `yaml:"update_interval" env:"GEOIP_UPDATE_INTERVAL"`
+		UpdateSchedule     string `yaml:"update_schedule" env:"GEOIP_UPDATE_SCHEDULE"`
+		UpdateJitter       string `yaml:"update_jitter" env:"GEOIP_UPDATE_JITTER"`
+		UpdateIfOlderThan  string `yaml:"update_if_older_than" env:"GEOIP_UPDATE_IF_OLDER_THAN"`
+		MaxDatabaseAge     string `yaml:"max_database_age" env:"GEOIP_MAX_DATABASE_AGE"`
+		MaxMindURL         string `yaml:"maxmind_url" env:"MAXMIND_DOWNLOAD_URL"`
+		DBIPUrl            string `yaml:"dbip_url" env:"DBIP_DOWNLOAD_URL"`
+		DBIPExpectedSHA256 string `yaml:"dbip_expected_sha256" env:"DBIP_EXPECTED_SHA256"`
+		DBIPChecksumURL    string `yaml:"dbip_checksum_url" env:"DBIP_CHECKSUM_URL"`
+		PreferDBIP         bool   `yaml:"prefer_dbip" env:"PREFER_DBIP"`
+
+		Providers []ProviderConfig `yaml:"providers" env:"GEOIP_PROVIDERS"`
+
+		City DatabaseConfig `yaml:"city"`
+		ASN  DatabaseConfig `yaml:"asn"`
+
+		Cache struct {
+			Enabled    bool   `yaml:"enabled" env:"GEOIP_CACHE_ENABLED"`
+			MaxEntries int    `yaml:"max_entries" env:"GEOIP_CACHE_MAX_ENTRIES"`
+			MaxMemory  string `yaml:"max_memory" env:"GEOIP_CACHE_MAX_MEMORY"`
+			TTL        string `yaml:"ttl" env:"GEOIP_CACHE_TTL"`
+		} `yaml:"cache"`
+
+		Download struct {
+			ConnectTimeout string `yaml:"connect_timeout" env:"GEOIP_DOWNLOAD_CONNECT_TIMEOUT"`
+			Timeout        string `yaml:"timeout" env:"GEOIP_DOWNLOAD_TIMEOUT"`
+			ProxyURL       string `yaml:"proxy_url" env:"GEOIP_DOWNLOAD_PROXY_URL"`
+			CABundle       string `yaml:"ca_bundle" env:"GEOIP_DOWNLOAD_CA_BUNDLE"`
+			UserAgent      string `yaml:"user_agent" env:"GEOIP_DOWNLOAD_USER_AGENT"`
+			Retries        int    `yaml:"retries" env:"GEOIP_DOWNLOAD_RETRIES"`
+			RetryBackoff   string `yaml:"retry_backoff" env:"GEOIP_DOWNLOAD_RETRY_BACKOFF"`
+		} `yaml:"download"`
 	} `yaml:"geoip"`
 
 	Security struct {
-		BlockIPParam bool `yaml:"block_ip_param" env:"BLOCK_IP_PARAM"`
+		BlockIPParam   bool     `yaml:"block_ip_param" env:"BLOCK_IP_PARAM"`
+		TrustedProxies []string `yaml:"trusted_proxies" env:"TRUSTED_PROXIES"`
+		ClientIPHeader string   `yaml:"client_ip_header" env:"CLIENT_IP_HEADER"`
+		AdminToken     string   `yaml:"admin_token" env:"ADMIN_TOKEN"`
 	} `yaml:"security"`
 }
 
+// ProviderConfig configures one entry of the ordered database provider chain
+type ProviderConfig struct {
+	Type           string            `yaml:"type"` // maxmind, dbip, url or file
+	Name           string            `yaml:"name"`
+	URL            string            `yaml:"url"`
+	Path           string            `yaml:"path"`
+	APIKey         string            `yaml:"api_key"`
+	Edition        string            `yaml:"edition"`
+	ExpectedSHA256 string            `yaml:"expected_sha256"`
+	ChecksumURL    string            `yaml:"checksum_url"`
+	Headers        map[string]string `yaml:"headers"`
+	Watch          bool              `yaml:"watch"`
+}
+
+// DatabaseConfig configures an optional additional database, like the city
+// or ASN database, which is downloaded and updated independently
+type DatabaseConfig struct {
+	Enabled        bool             `yaml:"enabled"`
+	DatabasePath   string           `yaml:"database_path"`
+	MaxMindEdition string           `yaml:"maxmind_edition"`
+	DBIPUrl        string           `yaml:"dbip_url"`
+	Providers      []ProviderConfig `yaml:"providers"`
+}
+
 func Load() (*Config, error) {
 	cfg := &Config{}
 
 	// Set defaults
 	cfg.Server.Port = "8080"
 	cfg.Server.Host = "0.0.0.0"
+	cfg.Server.MaxBatchSize = 1000
+	cfg.Server.ShutdownTimeout = "30s"
+	cfg.Server.LogLevel = "info"
 	cfg.GeoIP.DatabasePath = "./data/GeoLite2-Country.mmdb"
 	cfg.GeoIP.UpdateInterval = "720h" // 30 days
 	cfg.GeoIP.MaxMindURL = "https://download.maxmind.com/app/geoip_download"
 	cfg.GeoIP.DBIPUrl = "https://download.db-ip.com/free/dbip-country-lite-{YYYY-MM}.mmdb.gz"
 	cfg.GeoIP.PreferDBIP = false
+	cfg.GeoIP.City.DatabasePath = "./data/GeoLite2-City.mmdb"
+	cfg.GeoIP.City.MaxMindEdition = "GeoLite2-City"
+	cfg.GeoIP.City.DBIPUrl = "https://download.db-ip.com/free/dbip-city-lite-{YYYY-MM}.mmdb.gz"
+	cfg.GeoIP.ASN.DatabasePath = "./data/GeoLite2-ASN.mmdb"
+	cfg.GeoIP.ASN.MaxMindEdition = "GeoLite2-ASN"
+	cfg.GeoIP.ASN.DBIPUrl = "https://download.db-ip.com/free/dbip-asn-lite-{YYYY-MM}.mmdb.gz"
+	cfg.GeoIP.Cache.MaxEntries = 100000
+	cfg.GeoIP.Cache.TTL = "1h"
+	cfg.GeoIP.Download.ConnectTimeout = "30s"
+	cfg.GeoIP.Download.Timeout = "10m"
+	cfg.GeoIP.Download.UserAgent = "micro_geoip"
+	cfg.GeoIP.Download.Retries = 3
+	cfg.GeoIP.Download.RetryBackoff = "5s"
 	cfg.Security.BlockIPParam = false
 
 	// Try to load from config file
@@ -97,6 +178,30 @@ func loadFromEnv(cfg *Config) {
 	if host := os.Getenv("HOST"); host != "" {
 		cfg.Server.Host = host
 	}
+	if maxBatchSize := os.Getenv("MAX_BATCH_SIZE"); maxBatchSize != "" {
+		if val, err := strconv.Atoi(maxBatchSize); err == nil {
+			cfg.Server.MaxBatchSize = val
+		}
+	}
+	if shutdownTimeout := os.Getenv("SHUTDOWN_TIMEOUT"); shutdownTimeout != "" {
+		cfg.Server.ShutdownTimeout = shutdownTimeout
+	}
+	if logLevel := os.Getenv("LOG_LEVEL"); logLevel != "" {
+		cfg.Server.LogLevel = logLevel
+	}
+	if proxyProtocol := os.Getenv("PROXY_PROTOCOL"); proxyProtocol != "" {
+		if val, err := strconv.ParseBool(proxyProtocol); err == nil {
+			cfg.Server.ProxyProtocol.Enabled = val
+		}
+	}
+	if proxyProtocolRequired := os.Getenv("PROXY_PROTOCOL_REQUIRED"); proxyProtocolRequired != "" {
+		if val, err := strconv.ParseBool(proxyProtocolRequired); err == nil {
+			cfg.Server.ProxyProtocol.Required = val
+		}
+	}
+	if trustedSources := os.Getenv("PROXY_PROTOCOL_TRUSTED_SOURCES"); trustedSources != "" {
+		cfg.Server.ProxyProtocol.TrustedSources = splitList(trustedSources)
+	}
 	if apiKey := os.Getenv("MAXMIND_API_KEY"); apiKey != "" {
 		cfg.GeoIP.MaxMindAPIKey = apiKey
 	}
@@ -106,22 +211,130 @@ func loadFromEnv(cfg *Config) {
 	if updateInterval := os.Getenv("GEOIP_UPDATE_INTERVAL"); updateInterval != "" {
 		cfg.GeoIP.UpdateInterval = updateInterval
 	}
+	if updateSchedule := os.Getenv("GEOIP_UPDATE_SCHEDULE"); updateSchedule != "" {
+		cfg.GeoIP.UpdateSchedule = updateSchedule
+	}
+	if updateJitter := os.Getenv("GEOIP_UPDATE_JITTER"); updateJitter != "" {
+		cfg.GeoIP.UpdateJitter = updateJitter
+	}
+	if updateIfOlderThan := os.Getenv("GEOIP_UPDATE_IF_OLDER_THAN"); updateIfOlderThan != "" {
+		cfg.GeoIP.UpdateIfOlderThan = updateIfOlderThan
+	}
+	if maxDatabaseAge := os.Getenv("GEOIP_MAX_DATABASE_AGE"); maxDatabaseAge != "" {
+		cfg.GeoIP.MaxDatabaseAge = maxDatabaseAge
+	}
 	if maxmindURL := os.Getenv("MAXMIND_DOWNLOAD_URL"); maxmindURL != "" {
 		cfg.GeoIP.MaxMindURL = maxmindURL
 	}
 	if dbipURL := os.Getenv("DBIP_DOWNLOAD_URL"); dbipURL != "" {
 		cfg.GeoIP.DBIPUrl = dbipURL
 	}
+	if dbipSHA256 := os.Getenv("DBIP_EXPECTED_SHA256"); dbipSHA256 != "" {
+		cfg.GeoIP.DBIPExpectedSHA256 = dbipSHA256
+	}
+	if dbipChecksumURL := os.Getenv("DBIP_CHECKSUM_URL"); dbipChecksumURL != "" {
+		cfg.GeoIP.DBIPChecksumURL = dbipChecksumURL
+	}
 	if preferDBIP := os.Getenv("PREFER_DBIP"); preferDBIP != "" {
 		if val, err := strconv.ParseBool(preferDBIP); err == nil {
 			cfg.GeoIP.PreferDBIP = val
 		}
 	}
+	if cityEnabled := os.Getenv("GEOIP_CITY_ENABLED"); cityEnabled != "" {
+		if val, err := strconv.ParseBool(cityEnabled); err == nil {
+			cfg.GeoIP.City.Enabled = val
+		}
+	}
+	if cityDBPath := os.Getenv("GEOIP_CITY_DB_PATH"); cityDBPath != "" {
+		cfg.GeoIP.City.DatabasePath = cityDBPath
+	}
+	if cityDBIPURL := os.Getenv("GEOIP_CITY_DBIP_DOWNLOAD_URL"); cityDBIPURL != "" {
+		cfg.GeoIP.City.DBIPUrl = cityDBIPURL
+	}
+	if asnEnabled := os.Getenv("GEOIP_ASN_ENABLED"); asnEnabled != "" {
+		if val, err := strconv.ParseBool(asnEnabled); err == nil {
+			cfg.GeoIP.ASN.Enabled = val
+		}
+	}
+	if asnDBPath := os.Getenv("GEOIP_ASN_DB_PATH"); asnDBPath != "" {
+		cfg.GeoIP.ASN.DatabasePath = asnDBPath
+	}
+	if asnDBIPURL := os.Getenv("GEOIP_ASN_DBIP_DOWNLOAD_URL"); asnDBIPURL != "" {
+		cfg.GeoIP.ASN.DBIPUrl = asnDBIPURL
+	}
+	if cacheEnabled := os.Getenv("GEOIP_CACHE_ENABLED"); cacheEnabled != "" {
+		if val, err := strconv.ParseBool(cacheEnabled); err == nil {
+			cfg.GeoIP.Cache.Enabled = val
+		}
+	}
+	if cacheMaxEntries := os.Getenv("GEOIP_CACHE_MAX_ENTRIES"); cacheMaxEntries != "" {
+		if val, err := strconv.Atoi(cacheMaxEntries); err == nil {
+			cfg.GeoIP.Cache.MaxEntries = val
+		}
+	}
+	if cacheMaxMemory := os.Getenv("GEOIP_CACHE_MAX_MEMORY"); cacheMaxMemory != "" {
+		cfg.GeoIP.Cache.MaxMemory = cacheMaxMemory
+	}
+	if cacheTTL := os.Getenv("GEOIP_CACHE_TTL"); cacheTTL != "" {
+		cfg.GeoIP.Cache.TTL = cacheTTL
+	}
+	if connectTimeout := os.Getenv("GEOIP_DOWNLOAD_CONNECT_TIMEOUT"); connectTimeout != "" {
+		cfg.GeoIP.Download.ConnectTimeout = connectTimeout
+	}
+	if timeout := os.Getenv("GEOIP_DOWNLOAD_TIMEOUT"); timeout != "" {
+		cfg.GeoIP.Download.Timeout = timeout
+	}
+	if proxyURL := os.Getenv("GEOIP_DOWNLOAD_PROXY_URL"); proxyURL != "" {
+		cfg.GeoIP.Download.ProxyURL = proxyURL
+	}
+	if caBundle := os.Getenv("GEOIP_DOWNLOAD_CA_BUNDLE"); caBundle != "" {
+		cfg.GeoIP.Download.CABundle = caBundle
+	}
+	if userAgent := os.Getenv("GEOIP_DOWNLOAD_USER_AGENT"); userAgent != "" {
+		cfg.GeoIP.Download.UserAgent = userAgent
+	}
+	if retries := os.Getenv("GEOIP_DOWNLOAD_RETRIES"); retries != "" {
+		if val, err := strconv.Atoi(retries); err == nil {
+			cfg.GeoIP.Download.Retries = val
+		}
+	}
+	if retryBackoff := os.Getenv("GEOIP_DOWNLOAD_RETRY_BACKOFF"); retryBackoff != "" {
+		cfg.GeoIP.Download.RetryBackoff = retryBackoff
+	}
+	if providers := os.Getenv("GEOIP_PROVIDERS"); providers != "" {
+		// Only the order can be set from the environment, credentials come from the other variables
+		cfg.GeoIP.Providers = nil
+		for _, providerType := range strings.Split(providers, ",") {
+			if providerType = strings.TrimSpace(providerType); providerType != "" {
+				cfg.GeoIP.Providers = append(cfg.GeoIP.Providers, ProviderConfig{Type: providerType})
+			}
+		}
+	}
 	if blockIP := os.Getenv("BLOCK_IP_PARAM"); blockIP != "" {
 		if val, err := strconv.ParseBool(blockIP); err == nil {
 			cfg.Security.BlockIPParam = val
 		}
 	}
+	if trustedProxies := os.Getenv("TRUSTED_PROXIES"); trustedProxies != "" {
+		cfg.Security.TrustedProxies = splitList(trustedProxies)
+	}
+	if clientIPHeader := os.Getenv("CLIENT_IP_HEADER"); clientIPHeader != "" {
+		cfg.Security.ClientIPHeader = clientIPHeader
+	}
+	if adminToken := os.Getenv("ADMIN_TOKEN"); adminToken != "" {
+		cfg.Security.AdminToken = adminToken
+	}
+}
+
+// splitList splits a comma separated environment variable into its non-empty entries
+func splitList(value string) []string {
+	var entries []string
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
 }
 
 func (c *Config) GetDatabaseDir() string {
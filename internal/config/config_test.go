@@ -40,6 +40,26 @@ func TestLoad(t *testing.T) {
 	if cfg.Security.BlockIPParam != false {
 		t.Errorf("Expected default BlockIPParam false, got %v", cfg.Security.BlockIPParam)
 	}
+
+	if cfg.Server.ShutdownTimeout != "30s" {
+		t.Errorf("Expected default shutdown timeout 30s, got %s", cfg.Server.ShutdownTimeout)
+	}
+
+	if cfg.Server.LogLevel != "info" {
+		t.Errorf("Expected default log level info, got %s", cfg.Server.LogLevel)
+	}
+
+	if cfg.Server.MaxBatchSize != 1000 {
+		t.Errorf("Expected default max batch size 1000, got %d", cfg.Server.MaxBatchSize)
+	}
+
+	if cfg.GeoIP.Download.Timeout != "10m" {
+		t.Errorf("Expected default download timeout 10m, got %s", cfg.GeoIP.Download.Timeout)
+	}
+
+	if cfg.GeoIP.Download.Retries != 3 {
+		t.Errorf("Expected default download retries 3, got %d", cfg.GeoIP.Download.Retries)
+	}
 }
 
 func TestLoadFromEnv(t *testing.T) {
@@ -48,11 +68,15 @@ func TestLoadFromEnv(t *testing.T) {
 	os.Setenv("MAXMIND_API_KEY", "test-key")
 	os.Setenv("BLOCK_IP_PARAM", "true")
 	os.Setenv("PREFER_DBIP", "true")
+	os.Setenv("GEOIP_UPDATE_SCHEDULE", "0 3 * * 1")
+	os.Setenv("GEOIP_UPDATE_JITTER", "2h")
 	defer func() {
 		os.Unsetenv("PORT")
 		os.Unsetenv("MAXMIND_API_KEY")
 		os.Unsetenv("BLOCK_IP_PARAM")
 		os.Unsetenv("PREFER_DBIP")
+		os.Unsetenv("GEOIP_UPDATE_SCHEDULE")
+		os.Unsetenv("GEOIP_UPDATE_JITTER")
 	}()
 
 	cfg, err := Load()
@@ -75,6 +99,167 @@ func TestLoadFromEnv(t *testing.T) {
 	if cfg.GeoIP.PreferDBIP != true {
 		t.Errorf("Expected PreferDBIP from env true, got %v", cfg.GeoIP.PreferDBIP)
 	}
+
+	if cfg.GeoIP.UpdateSchedule != "0 3 * * 1" {
+		t.Errorf("Expected UpdateSchedule from env '0 3 * * 1', got %s", cfg.GeoIP.UpdateSchedule)
+	}
+
+	if cfg.GeoIP.UpdateJitter != "2h" {
+		t.Errorf("Expected UpdateJitter from env 2h, got %s", cfg.GeoIP.UpdateJitter)
+	}
+}
+
+func TestLoadProvidersFromEnv(t *testing.T) {
+	os.Setenv("GEOIP_PROVIDERS", "dbip, maxmind,")
+	defer os.Unsetenv("GEOIP_PROVIDERS")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	if len(cfg.GeoIP.Providers) != 2 {
+		t.Fatalf("Expected 2 providers from env, got %d", len(cfg.GeoIP.Providers))
+	}
+
+	if cfg.GeoIP.Providers[0].Type != "dbip" || cfg.GeoIP.Providers[1].Type != "maxmind" {
+		t.Errorf("Expected providers dbip, maxmind, got %+v", cfg.GeoIP.Providers)
+	}
+}
+
+func TestLoadCityFromEnv(t *testing.T) {
+	os.Setenv("GEOIP_CITY_ENABLED", "true")
+	os.Setenv("GEOIP_CITY_DB_PATH", "/tmp/city.mmdb")
+	defer func() {
+		os.Unsetenv("GEOIP_CITY_ENABLED")
+		os.Unsetenv("GEOIP_CITY_DB_PATH")
+	}()
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	if !cfg.GeoIP.City.Enabled {
+		t.Error("Expected city database to be enabled from env")
+	}
+
+	if cfg.GeoIP.City.DatabasePath != "/tmp/city.mmdb" {
+		t.Errorf("Expected city database path from env /tmp/city.mmdb, got %s", cfg.GeoIP.City.DatabasePath)
+	}
+
+	if cfg.GeoIP.City.MaxMindEdition != "GeoLite2-City" {
+		t.Errorf("Expected default city edition GeoLite2-City, got %s", cfg.GeoIP.City.MaxMindEdition)
+	}
+}
+
+func TestLoadASNFromEnv(t *testing.T) {
+	os.Setenv("GEOIP_ASN_ENABLED", "true")
+	os.Setenv("GEOIP_ASN_DB_PATH", "/tmp/asn.mmdb")
+	defer func() {
+		os.Unsetenv("GEOIP_ASN_ENABLED")
+		os.Unsetenv("GEOIP_ASN_DB_PATH")
+	}()
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	if !cfg.GeoIP.ASN.Enabled {
+		t.Error("Expected ASN database to be enabled from env")
+	}
+
+	if cfg.GeoIP.ASN.DatabasePath != "/tmp/asn.mmdb" {
+		t.Errorf("Expected ASN database path from env /tmp/asn.mmdb, got %s", cfg.GeoIP.ASN.DatabasePath)
+	}
+
+	if cfg.GeoIP.ASN.MaxMindEdition != "GeoLite2-ASN" {
+		t.Errorf("Expected default ASN edition GeoLite2-ASN, got %s", cfg.GeoIP.ASN.MaxMindEdition)
+	}
+}
+
+func TestLoadCacheFromEnv(t *testing.T) {
+	os.Setenv("GEOIP_CACHE_ENABLED", "true")
+	os.Setenv("GEOIP_CACHE_MAX_ENTRIES", "5000")
+	os.Setenv("GEOIP_CACHE_MAX_MEMORY", "64MiB")
+	os.Setenv("GEOIP_CACHE_TTL", "10m")
+	defer func() {
+		os.Unsetenv("GEOIP_CACHE_ENABLED")
+		os.Unsetenv("GEOIP_CACHE_MAX_ENTRIES")
+		os.Unsetenv("GEOIP_CACHE_MAX_MEMORY")
+		os.Unsetenv("GEOIP_CACHE_TTL")
+	}()
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	cache := cfg.GeoIP.Cache
+	if !cache.Enabled || cache.MaxEntries != 5000 || cache.MaxMemory != "64MiB" || cache.TTL != "10m" {
+		t.Errorf("Expected cache settings from env, got %+v", cache)
+	}
+}
+
+func TestLoadTrustedProxiesFromEnv(t *testing.T) {
+	os.Setenv("TRUSTED_PROXIES", "10.0.0.0/8, 2001:db8::/32")
+	os.Setenv("CLIENT_IP_HEADER", "CF-Connecting-IP")
+	defer func() {
+		os.Unsetenv("TRUSTED_PROXIES")
+		os.Unsetenv("CLIENT_IP_HEADER")
+	}()
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	if len(cfg.Security.TrustedProxies) != 2 || cfg.Security.TrustedProxies[1] != "2001:db8::/32" {
+		t.Errorf("Expected trusted proxies from env, got %v", cfg.Security.TrustedProxies)
+	}
+
+	if cfg.Security.ClientIPHeader != "CF-Connecting-IP" {
+		t.Errorf("Expected client IP header from env CF-Connecting-IP, got %s", cfg.Security.ClientIPHeader)
+	}
+}
+
+func TestLoadAdminTokenFromEnv(t *testing.T) {
+	os.Setenv("ADMIN_TOKEN", "secret")
+	defer os.Unsetenv("ADMIN_TOKEN")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	if cfg.Security.AdminToken != "secret" {
+		t.Errorf("Expected admin token from env, got %q", cfg.Security.AdminToken)
+	}
+}
+
+func TestLoadProxyProtocolFromEnv(t *testing.T) {
+	os.Setenv("PROXY_PROTOCOL", "true")
+	os.Setenv("PROXY_PROTOCOL_REQUIRED", "true")
+	os.Setenv("PROXY_PROTOCOL_TRUSTED_SOURCES", "10.0.0.0/8,,192.168.0.0/16")
+	defer func() {
+		os.Unsetenv("PROXY_PROTOCOL")
+		os.Unsetenv("PROXY_PROTOCOL_REQUIRED")
+		os.Unsetenv("PROXY_PROTOCOL_TRUSTED_SOURCES")
+	}()
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	if !cfg.Server.ProxyProtocol.Enabled || !cfg.Server.ProxyProtocol.Required {
+		t.Errorf("Expected PROXY protocol to be enabled and required from env, got %+v", cfg.Server.ProxyProtocol)
+	}
+
+	if len(cfg.Server.ProxyProtocol.TrustedSources) != 2 {
+		t.Errorf("Expected 2 trusted sources from env, got %v", cfg.Server.ProxyProtocol.TrustedSources)
+	}
 }
 
 func TestGetDatabaseDir(t *testing.T) {
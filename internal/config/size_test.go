@@ -0,0 +1,55 @@
+/*
+ * Copyright (C) 2025  GeorgH93
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package config
+
+import "testing"
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected int64
+	}{
+		{"1048576", 1 << 20},
+		{"64MiB", 64 << 20},
+		{"64Mi", 64 << 20},
+		{"100MB", 100e6},
+		{"1.5 GB", 1.5e9},
+		{"512k", 512e3},
+		{"2KiB", 2048},
+		{"10b", 10},
+	}
+
+	for _, tt := range tests {
+		size, err := ParseSize(tt.value)
+		if err != nil {
+			t.Errorf("Failed to parse %q: %v", tt.value, err)
+			continue
+		}
+		if size != tt.expected {
+			t.Errorf("Expected %q to be %d bytes, got %d", tt.value, tt.expected, size)
+		}
+	}
+}
+
+func TestParseSizeInvalid(t *testing.T) {
+	for _, value := range []string{"", "MB", "-1MB", "ten", "10TB"} {
+		if _, err := ParseSize(value); err == nil {
+			t.Errorf("Expected %q to be rejected", value)
+		}
+	}
+}
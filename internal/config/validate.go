@@ -0,0 +1,126 @@
+/*
+ * Copyright (C) 2025  GeorgH93
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"time"
+
+	"micro_geoip/internal/logging"
+
+	"github.com/robfig/cron/v3"
+)
+
+// Validate checks the settings that are otherwise only parsed when they are
+// used, so a broken configuration can be rejected as a whole
+func (c *Config) Validate() error {
+	var errs []error
+
+	durations := map[string]string{
+		"server.shutdown_timeout":        c.Server.ShutdownTimeout,
+		"geoip.update_interval":          c.GeoIP.UpdateInterval,
+		"geoip.update_jitter":            c.GeoIP.UpdateJitter,
+		"geoip.update_if_older_than":     c.GeoIP.UpdateIfOlderThan,
+		"geoip.max_database_age":         c.GeoIP.MaxDatabaseAge,
+		"geoip.cache.ttl":                c.GeoIP.Cache.TTL,
+		"geoip.download.connect_timeout": c.GeoIP.Download.ConnectTimeout,
+		"geoip.download.timeout":         c.GeoIP.Download.Timeout,
+		"geoip.download.retry_backoff":   c.GeoIP.Download.RetryBackoff,
+	}
+	for name, value := range durations {
+		if value == "" {
+			continue
+		}
+		if _, err := time.ParseDuration(value); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+
+	if c.GeoIP.UpdateSchedule != "" {
+		if _, err := cron.ParseStandard(c.GeoIP.UpdateSchedule); err != nil {
+			errs = append(errs, fmt.Errorf("geoip.update_schedule: %w", err))
+		}
+	}
+
+	if c.GeoIP.Cache.MaxMemory != "" {
+		if _, err := ParseSize(c.GeoIP.Cache.MaxMemory); err != nil {
+			errs = append(errs, fmt.Errorf("geoip.cache.max_memory: %w", err))
+		}
+	}
+
+	if _, err := logging.ParseLevel(c.Server.LogLevel); err != nil {
+		errs = append(errs, fmt.Errorf("server.log_level: %w", err))
+	}
+
+	errs = append(errs, validateNetworks("server.proxy_protocol.trusted_sources", c.Server.ProxyProtocol.TrustedSources)...)
+	errs = append(errs, validateNetworks("security.trusted_proxies", c.Security.TrustedProxies)...)
+
+	return errors.Join(errs...)
+}
+
+// validateNetworks checks that all entries are IPs or CIDRs
+func validateNetworks(name string, entries []string) []error {
+	var errs []error
+	for _, entry := range entries {
+		if _, _, err := net.ParseCIDR(entry); err == nil || net.ParseIP(entry) != nil {
+			continue
+		}
+		errs = append(errs, fmt.Errorf("%s: invalid IP or CIDR '%s'", name, entry))
+	}
+	return errs
+}
+
+// Changed returns the settings that differ between two configurations by
+// their YAML path, e.g. "geoip.update_schedule". Lists and maps are
+// compared as a whole.
+func Changed(old, new *Config) []string {
+	return changedFields("", reflect.ValueOf(*old), reflect.ValueOf(*new))
+}
+
+func changedFields(prefix string, old, new reflect.Value) []string {
+	var changed []string
+	for i := 0; i < old.NumField(); i++ {
+		field := old.Type().Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if name == "" || name == "-" {
+			name = strings.ToLower(field.Name)
+		}
+		path := prefix + name
+
+		oldValue, newValue := old.Field(i), new.Field(i)
+		switch field.Type.Kind() {
+		case reflect.Struct:
+			changed = append(changed, changedFields(path+".", oldValue, newValue)...)
+		case reflect.Slice, reflect.Map:
+			// An empty list is the same as a missing one
+			if oldValue.Len() == 0 && newValue.Len() == 0 {
+				continue
+			}
+			fallthrough
+		default:
+			if !reflect.DeepEqual(oldValue.Interface(), newValue.Interface()) {
+				changed = append(changed, path)
+			}
+		}
+	}
+	return changed
+}
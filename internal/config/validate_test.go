@@ -0,0 +1,74 @@
+/*
+ * Copyright (C) 2025  GeorgH93
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Expected the default configuration to be valid, got %v", err)
+	}
+
+	cfg.GeoIP.UpdateJitter = "1 hour"
+	cfg.GeoIP.UpdateSchedule = "every monday"
+	cfg.Server.LogLevel = "verbose"
+	cfg.Security.TrustedProxies = []string{"10.0.0.0/8", "proxy.example.com"}
+	cfg.GeoIP.Cache.MaxMemory = "64 megabytes"
+
+	err = cfg.Validate()
+	if err == nil {
+		t.Fatal("Expected invalid configuration to be rejected")
+	}
+	for _, name := range []string{"geoip.update_jitter", "geoip.update_schedule", "server.log_level", "proxy.example.com", "geoip.cache.max_memory"} {
+		if !strings.Contains(err.Error(), name) {
+			t.Errorf("Expected error to mention %s, got %v", name, err)
+		}
+	}
+}
+
+func TestChanged(t *testing.T) {
+	old, err := Load()
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	new := *old
+
+	if changed := Changed(old, &new); len(changed) != 0 {
+		t.Errorf("Expected no changes, got %v", changed)
+	}
+
+	new.Server.Port = "9090"
+	new.Security.BlockIPParam = true
+	new.GeoIP.Download.Retries = 5
+	new.GeoIP.City.Providers = []ProviderConfig{{Type: "dbip"}}
+	new.Security.TrustedProxies = []string{}
+
+	expected := []string{"server.port", "geoip.city.providers", "geoip.download.retries", "security.block_ip_param"}
+	if changed := Changed(old, &new); !reflect.DeepEqual(changed, expected) {
+		t.Errorf("Expected changes %v, got %v", expected, changed)
+	}
+}
@@ -0,0 +1,322 @@
+/*
+ * Copyright (C) 2025  GeorgH93
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package geoip
+
+import (
+	"container/list"
+	"hash/maphash"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"micro_geoip/internal/config"
+	"micro_geoip/internal/logging"
+	"micro_geoip/internal/metrics"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// cacheShards is the number of independently locked parts of the cache
+const cacheShards = 16
+
+// cacheEntryOverhead approximates the memory used by the map entry, list
+// element and cacheEntry of a cached lookup besides the key and value
+const cacheEntryOverhead = 200
+
+// cacheKind tells the lookups of the different databases apart
+type cacheKind uint8
+
+const (
+	cacheCountry cacheKind = iota
+	cacheLocation
+	cacheASN
+)
+
+var cacheKindNames = [...]string{"country", "city", "asn"}
+
+// generational is implemented by services that report database swaps
+type generational interface {
+	Generation() uint64
+}
+
+// CacheStats are the counters of a lookup cache
+type CacheStats struct {
+	Hits    uint64
+	Misses  uint64
+	Entries int
+	Bytes   int64 // Approximate memory used by the entries
+}
+
+// CachedService caches the lookups of another service in a sharded LRU cache.
+// Entries expire after the TTL and are dropped when a database is swapped, if
+// the service reports swaps like Service does, or updated or reloaded through
+// the cache.
+type CachedService struct {
+	GeoIPService
+
+	shards     [cacheShards]cacheShard
+	seed       maphash.Seed
+	ttl        time.Duration
+	generation func() uint64
+
+	hits, misses atomic.Uint64
+	hitCounters  [len(cacheKindNames)]prometheus.Counter
+	missCounters [len(cacheKindNames)]prometheus.Counter
+}
+
+// cacheKey identifies a cached lookup
+type cacheKey struct {
+	kind cacheKind
+	ip   string
+}
+
+type cacheEntry struct {
+	key     cacheKey
+	value   any
+	size    int64
+	expires time.Time
+}
+
+// cacheShard is a part of the cache with its own lock and share of the limits
+type cacheShard struct {
+	mu         sync.Mutex
+	entries    map[cacheKey]*list.Element
+	lru        list.List // most recently used first
+	generation uint64
+	bytes      int64
+	maxEntries int
+	maxBytes   int64
+}
+
+// NewCachedService puts a lookup cache configured by cfg in front of service
+func NewCachedService(service GeoIPService, cfg *config.Config) *CachedService {
+	maxEntries := cfg.GeoIP.Cache.MaxEntries
+	var maxBytes int64
+	if cfg.GeoIP.Cache.MaxMemory != "" {
+		size, err := config.ParseSize(cfg.GeoIP.Cache.MaxMemory)
+		if err != nil {
+			logging.Warnf("Invalid cache max memory '%s', ignoring it: %v", cfg.GeoIP.Cache.MaxMemory, err)
+		}
+		maxBytes = size
+	}
+	if maxEntries <= 0 && maxBytes <= 0 {
+		logging.Warnf("Cache has neither max entries nor max memory, limiting it to 100000 entries")
+		maxEntries = 100000
+	}
+
+	return newCachedService(service, maxEntries, maxBytes, parseOptionalDuration("cache TTL", cfg.GeoIP.Cache.TTL))
+}
+
+func newCachedService(service GeoIPService, maxEntries int, maxBytes int64, ttl time.Duration) *CachedService {
+	c := &CachedService{
+		GeoIPService: service,
+		seed:         maphash.MakeSeed(),
+		ttl:          ttl,
+		generation:   func() uint64 { return 0 },
+	}
+	if g, ok := service.(generational); ok {
+		c.generation = g.Generation
+	}
+
+	for i := range c.shards {
+		shard := &c.shards[i]
+		shard.entries = make(map[cacheKey]*list.Element)
+		if maxEntries > 0 {
+			shard.maxEntries = max(1, (maxEntries+cacheShards-1)/cacheShards)
+		}
+		if maxBytes > 0 {
+			shard.maxBytes = max(1, (maxBytes+cacheShards-1)/cacheShards)
+		}
+	}
+	for kind, name := range cacheKindNames {
+		c.hitCounters[kind] = metrics.CacheHits.WithLabelValues(name)
+		c.missCounters[kind] = metrics.CacheMisses.WithLabelValues(name)
+	}
+
+	return c
+}
+
+func (c *CachedService) GetCountry(ip string) (*CountryInfo, error) {
+	country, hit, err := cachedLookup(c, cacheCountry, ip, c.GeoIPService.GetCountry, func(country *CountryInfo) int {
+		return len(country.Code) + len(country.Name)
+	})
+
+	// Keep counting the lookups by country like Service does
+	if hit {
+		metrics.Lookups.WithLabelValues(country.Code).Inc()
+	}
+	return country, err
+}
+
+func (c *CachedService) GetLocation(ip string) (*LocationInfo, error) {
+	location, _, err := cachedLookup(c, cacheLocation, ip, c.GeoIPService.GetLocation, func(location *LocationInfo) int {
+		return len(location.Code) + len(location.Name) + len(location.City) + len(location.Subdivision) +
+			len(location.SubdivisionCode) + len(location.PostalCode) + len(location.TimeZone)
+	})
+	return location, err
+}
+
+func (c *CachedService) GetASN(ip string) (*ASNInfo, error) {
+	asn, _, err := cachedLookup(c, cacheASN, ip, c.GeoIPService.GetASN, func(asn *ASNInfo) int {
+		return len(asn.Organization)
+	})
+	return asn, err
+}
+
+// cachedLookup returns the cached result of a lookup or looks it up and
+// caches it. Failed lookups are not cached. The returned value is shared and
+// must not be modified.
+func cachedLookup[T any](c *CachedService, kind cacheKind, ip string, lookup func(string) (*T, error), stringBytes func(*T) int) (*T, bool, error) {
+	key := cacheKey{kind: kind, ip: ip}
+	shard := &c.shards[maphash.String(c.seed, ip)%cacheShards]
+
+	// Taken before the lookup, so results from a database that is swapped
+	// out meanwhile are discarded
+	generation := c.generation()
+
+	if value, ok := shard.get(key, generation, time.Now()); ok {
+		c.hits.Add(1)
+		c.hitCounters[kind].Inc()
+		return value.(*T), true, nil
+	}
+	c.misses.Add(1)
+	c.missCounters[kind].Inc()
+
+	value, err := lookup(ip)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var expires time.Time
+	if c.ttl > 0 {
+		expires = time.Now().Add(c.ttl)
+	}
+	size := cacheEntryOverhead + int64(len(ip)) + int64(unsafe.Sizeof(*value)) + int64(stringBytes(value))
+	shard.put(&cacheEntry{key: key, value: value, size: size, expires: expires}, generation)
+
+	return value, false, nil
+}
+
+// Update updates the databases of the wrapped service and clears the cache
+func (c *CachedService) Update() error {
+	defer c.Purge()
+	return c.GeoIPService.Update()
+}
+
+// Reload reloads the databases of the wrapped service and clears the cache
+func (c *CachedService) Reload() error {
+	defer c.Purge()
+	return c.GeoIPService.Reload()
+}
+
+// Purge removes all entries
+func (c *CachedService) Purge() {
+	for i := range c.shards {
+		shard := &c.shards[i]
+		shard.mu.Lock()
+		shard.clear()
+		shard.mu.Unlock()
+	}
+}
+
+// Stats returns the hit and miss counts and the size of the cache
+func (c *CachedService) Stats() CacheStats {
+	stats := CacheStats{Hits: c.hits.Load(), Misses: c.misses.Load()}
+	for i := range c.shards {
+		shard := &c.shards[i]
+		shard.mu.Lock()
+		stats.Entries += len(shard.entries)
+		stats.Bytes += shard.bytes
+		shard.mu.Unlock()
+	}
+	return stats
+}
+
+// get returns the value of a current entry and marks it as recently used
+func (s *cacheShard) get(key cacheKey, generation uint64, now time.Time) (any, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.advance(generation) {
+		return nil, false
+	}
+
+	element, ok := s.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := element.Value.(*cacheEntry)
+	if !entry.expires.IsZero() && now.After(entry.expires) {
+		s.remove(element)
+		return nil, false
+	}
+
+	s.lru.MoveToFront(element)
+	return entry.value, true
+}
+
+// put adds an entry looked up at the given generation and evicts the least
+// recently used entries beyond the limits
+func (s *cacheShard) put(entry *cacheEntry, generation uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.advance(generation) {
+		return
+	}
+
+	if element, ok := s.entries[entry.key]; ok {
+		s.remove(element)
+	}
+	s.entries[entry.key] = s.lru.PushFront(entry)
+	s.bytes += entry.size
+	metrics.CacheEntries.Inc()
+
+	for (s.maxEntries > 0 && len(s.entries) > s.maxEntries) || (s.maxBytes > 0 && s.bytes > s.maxBytes) {
+		s.remove(s.lru.Back())
+	}
+}
+
+// advance clears the shard when the databases have been swapped since its
+// entries were looked up. It returns false for a generation older than the
+// entries, whose results must not be used.
+func (s *cacheShard) advance(generation uint64) bool {
+	if generation < s.generation {
+		return false
+	}
+	if generation > s.generation {
+		s.clear()
+		s.generation = generation
+	}
+	return true
+}
+
+func (s *cacheShard) remove(element *list.Element) {
+	entry := s.lru.Remove(element).(*cacheEntry)
+	delete(s.entries, entry.key)
+	s.bytes -= entry.size
+	metrics.CacheEntries.Dec()
+}
+
+func (s *cacheShard) clear() {
+	metrics.CacheEntries.Sub(float64(len(s.entries)))
+	clear(s.entries)
+	s.lru.Init()
+	s.bytes = 0
+}
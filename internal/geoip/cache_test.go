@@ -0,0 +1,219 @@
+/*
+ * Copyright (C) 2025  GeorgH93
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package geoip
+
+import (
+	"container/list"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestCachedServiceHitsAndMisses(t *testing.T) {
+	mock := NewMockService()
+	cache := newCachedService(mock, 100, 0, time.Hour)
+
+	for i := 0; i < 3; i++ {
+		country, err := cache.GetCountry("8.8.8.8")
+		if err != nil {
+			t.Fatalf("Lookup failed: %v", err)
+		}
+		if country.Code != "US" {
+			t.Errorf("Expected US, got %s", country.Code)
+		}
+	}
+
+	// Location and ASN lookups are cached separately
+	if _, err := cache.GetLocation("8.8.8.8"); err == nil {
+		t.Error("Expected location lookup without city database to fail")
+	}
+
+	stats := cache.Stats()
+	if stats.Hits != 2 || stats.Misses != 2 {
+		t.Errorf("Expected 2 hits and 2 misses, got %+v", stats)
+	}
+	if stats.Entries != 1 || stats.Bytes <= 0 {
+		t.Errorf("Expected 1 entry with its size, got %+v", stats)
+	}
+}
+
+func TestCachedServiceDoesNotCacheErrors(t *testing.T) {
+	mock := NewMockService()
+	mock.Unavailable = true
+	cache := newCachedService(mock, 100, 0, 0)
+
+	if _, err := cache.GetCountry("8.8.8.8"); err == nil {
+		t.Fatal("Expected lookup to fail")
+	}
+
+	mock.Unavailable = false
+	country, err := cache.GetCountry("8.8.8.8")
+	if err != nil || country.Code != "US" {
+		t.Errorf("Expected US once the database is available, got %+v, %v", country, err)
+	}
+}
+
+func TestCachedServiceUpdatePurges(t *testing.T) {
+	mock := NewMockService()
+	cache := newCachedService(mock, 100, 0, 0)
+
+	cache.GetCountry("8.8.8.8")
+	mock.SetCountry("8.8.8.8", "CA", "Canada")
+	if err := cache.Update(); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+
+	country, _ := cache.GetCountry("8.8.8.8")
+	if country.Code != "CA" {
+		t.Errorf("Expected updated country CA, got %s", country.Code)
+	}
+	if mock.Updates != 1 {
+		t.Errorf("Expected update to be passed on, got %d updates", mock.Updates)
+	}
+}
+
+func TestCachedServiceInvalidatedOnSwap(t *testing.T) {
+	s := newTestService(t)
+	cache := newCachedService(s, 100, 0, 0)
+
+	cache.GetCountry("8.8.8.8")
+	cache.GetCountry("8.8.8.8")
+
+	// Reloading the service directly swaps the reader without the cache knowing
+	if err := s.Reload(); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+
+	country, err := cache.GetCountry("8.8.8.8")
+	if err != nil || country.Code != "US" {
+		t.Fatalf("Expected US after reload, got %+v, %v", country, err)
+	}
+	if stats := cache.Stats(); stats.Hits != 1 || stats.Misses != 2 {
+		t.Errorf("Expected a miss after the swap, got %+v", stats)
+	}
+}
+
+func newTestShard(maxEntries int, maxBytes int64) *cacheShard {
+	return &cacheShard{entries: make(map[cacheKey]*list.Element), maxEntries: maxEntries, maxBytes: maxBytes}
+}
+
+func putTestEntry(s *cacheShard, ip string, size int64, expires time.Time) {
+	s.put(&cacheEntry{key: cacheKey{ip: ip}, value: ip, size: size, expires: expires}, 0)
+}
+
+func TestCacheShardEvictsLeastRecentlyUsed(t *testing.T) {
+	s := newTestShard(2, 0)
+	now := time.Now()
+
+	putTestEntry(s, "a", 1, time.Time{})
+	putTestEntry(s, "b", 1, time.Time{})
+	s.get(cacheKey{ip: "a"}, 0, now)
+	putTestEntry(s, "c", 1, time.Time{})
+
+	for ip, expected := range map[string]bool{"a": true, "b": false, "c": true} {
+		if _, ok := s.get(cacheKey{ip: ip}, 0, now); ok != expected {
+			t.Errorf("Expected %s cached %v, got %v", ip, expected, ok)
+		}
+	}
+}
+
+func TestCacheShardMemoryLimit(t *testing.T) {
+	s := newTestShard(0, 250)
+
+	putTestEntry(s, "a", 100, time.Time{})
+	putTestEntry(s, "b", 100, time.Time{})
+	putTestEntry(s, "c", 100, time.Time{})
+
+	if len(s.entries) != 2 || s.bytes != 200 {
+		t.Errorf("Expected 2 entries with 200 bytes, got %d with %d bytes", len(s.entries), s.bytes)
+	}
+	if _, ok := s.get(cacheKey{ip: "a"}, 0, time.Now()); ok {
+		t.Error("Expected oldest entry to be evicted")
+	}
+}
+
+func TestCacheShardTTL(t *testing.T) {
+	s := newTestShard(10, 0)
+	now := time.Now()
+
+	putTestEntry(s, "a", 1, now.Add(time.Minute))
+	if _, ok := s.get(cacheKey{ip: "a"}, 0, now); !ok {
+		t.Error("Expected entry before expiry")
+	}
+	if _, ok := s.get(cacheKey{ip: "a"}, 0, now.Add(2*time.Minute)); ok {
+		t.Error("Expected expired entry to be dropped")
+	}
+	if len(s.entries) != 0 {
+		t.Errorf("Expected expired entry to be removed, got %d entries", len(s.entries))
+	}
+}
+
+func TestCacheShardGeneration(t *testing.T) {
+	s := newTestShard(10, 0)
+	now := time.Now()
+
+	putTestEntry(s, "a", 1, time.Time{})
+	if _, ok := s.get(cacheKey{ip: "a"}, 1, now); ok {
+		t.Error("Expected entries of an older generation to be dropped")
+	}
+
+	// Results looked up before the swap are not cached anymore
+	putTestEntry(s, "b", 1, time.Time{})
+	if len(s.entries) != 0 {
+		t.Errorf("Expected stale result to be discarded, got %d entries", len(s.entries))
+	}
+}
+
+// benchmarkIPs returns IPs of the test database with a skewed distribution,
+// a few of them make up most of the lookups
+func benchmarkIPs() []string {
+	var ips []string
+	for i := 0; i < 1000; i++ {
+		ips = append(ips, fmt.Sprintf("8.8.%d.%d", i/250%4, i%250))
+		if i%10 == 0 {
+			for j := 0; j < 9; j++ {
+				ips = append(ips, fmt.Sprintf("1.1.1.%d", j))
+			}
+		}
+	}
+	return ips
+}
+
+func benchmarkGetCountry(b *testing.B, service GeoIPService) {
+	ips := benchmarkIPs()
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			if _, err := service.GetCountry(ips[i%len(ips)]); err != nil {
+				b.Fatalf("Lookup failed: %v", err)
+			}
+			i++
+		}
+	})
+}
+
+func BenchmarkGetCountryUncached(b *testing.B) {
+	benchmarkGetCountry(b, newTestService(b))
+}
+
+func BenchmarkGetCountryCached(b *testing.B) {
+	benchmarkGetCountry(b, newCachedService(newTestService(b), 100000, 0, time.Hour))
+}
@@ -0,0 +1,66 @@
+/*
+ * Copyright (C) 2025  GeorgH93
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package geoip
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// ChecksumError is returned when a downloaded file doesn't match the SHA-256
+// digest published for it
+type ChecksumError struct {
+	Source   string // Name of the database source (e.g., "MaxMind")
+	Expected string // Expected hex encoded SHA-256 digest
+	Actual   string // Hex encoded SHA-256 digest of the downloaded file
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("%s checksum mismatch: expected sha256 %s, got %s", e.Source, e.Expected, e.Actual)
+}
+
+// parseChecksum extracts the digest from a sha256sum style line
+// ("<hex digest>  <file name>") or a bare hex digest
+func parseChecksum(data string) (string, error) {
+	fields := strings.Fields(data)
+	if len(fields) == 0 {
+		return "", fmt.Errorf("empty checksum")
+	}
+
+	digest := strings.ToLower(fields[0])
+	if decoded, err := hex.DecodeString(digest); err != nil || len(decoded) != 32 {
+		return "", fmt.Errorf("invalid sha256 checksum: %s", fields[0])
+	}
+
+	return digest, nil
+}
+
+// verifyChecksum compares the digest of a downloaded file with the expected one
+func verifyChecksum(source, expected string, actual []byte) error {
+	expected, err := parseChecksum(expected)
+	if err != nil {
+		return err
+	}
+
+	if actualHex := hex.EncodeToString(actual); actualHex != expected {
+		return &ChecksumError{Source: source, Expected: expected, Actual: actualHex}
+	}
+
+	return nil
+}
@@ -0,0 +1,183 @@
+/*
+ * Copyright (C) 2025  GeorgH93
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package geoip
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// maxMindArchive packs the database at dbPath like a MaxMind tar.gz edition
+func maxMindArchive(t *testing.T, dbPath string) []byte {
+	t.Helper()
+
+	data, err := os.ReadFile(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	gzw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gzw)
+	header := &tar.Header{
+		Name: "GeoLite2-Country_20250101/GeoLite2-Country.mmdb",
+		Mode: 0644,
+		Size: int64(len(data)),
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tw.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	tw.Close()
+	gzw.Close()
+
+	return buf.Bytes()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// newMaxMindServer serves archive and a checksum file like the MaxMind download endpoint
+func newMaxMindServer(t *testing.T, archive []byte, checksum string) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("license_key") != "test-key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch r.URL.Query().Get("suffix") {
+		case "tar.gz":
+			w.Write(archive)
+		case "tar.gz.sha256":
+			w.Write([]byte(checksum + "  GeoLite2-Country_20250101.tar.gz\n"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func TestDownloadMaxMindDatabaseVerifiesChecksum(t *testing.T) {
+	s := newTestService(t)
+	archive := maxMindArchive(t, s.config.GeoIP.DatabasePath)
+
+	server := newMaxMindServer(t, archive, sha256Hex(archive))
+	s.config.GeoIP.MaxMindURL = server.URL
+	s.config.GeoIP.MaxMindAPIKey = "test-key"
+
+	if err := fetchFrom(t, s, "maxmind"); err != nil {
+		t.Fatalf("MaxMind download failed: %v", err)
+	}
+}
+
+func TestDownloadMaxMindDatabaseRejectsChecksumMismatch(t *testing.T) {
+	s := newTestService(t)
+	archive := maxMindArchive(t, s.config.GeoIP.DatabasePath)
+
+	server := newMaxMindServer(t, archive, strings.Repeat("0", 64))
+	s.config.GeoIP.MaxMindURL = server.URL
+	s.config.GeoIP.MaxMindAPIKey = "test-key"
+
+	err := fetchFrom(t, s, "maxmind")
+
+	var checksumErr *ChecksumError
+	if !errors.As(err, &checksumErr) {
+		t.Fatalf("Expected ChecksumError, got %v", err)
+	}
+	if checksumErr.Actual != sha256Hex(archive) {
+		t.Errorf("Expected actual digest %s, got %s", sha256Hex(archive), checksumErr.Actual)
+	}
+
+	if _, err := os.Stat(s.config.GeoIP.DatabasePath + ".bak"); !os.IsNotExist(err) {
+		t.Error("Expected database not to be replaced after a checksum mismatch")
+	}
+}
+
+func TestDBIPChecksumVerification(t *testing.T) {
+	s := newTestService(t)
+	stub := newDBIPStub(t, s)
+
+	checksumServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/dbip-country-lite-"+time.Now().Format("2006-01")+".mmdb.gz.sha256" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(sha256Hex(stub.data)))
+	}))
+	defer checksumServer.Close()
+
+	s.config.GeoIP.DBIPExpectedSHA256 = strings.ToUpper(sha256Hex(stub.data))
+	if err := fetchFrom(t, s, "dbip"); err != nil {
+		t.Errorf("Expected matching checksum to pass, got %v", err)
+	}
+
+	// Changing the release makes the next request unconditional again
+	stub.etag = `"dbip-v2"`
+	s.config.GeoIP.DBIPExpectedSHA256 = strings.Repeat("f", 64)
+	var checksumErr *ChecksumError
+	if err := fetchFrom(t, s, "dbip"); !errors.As(err, &checksumErr) {
+		t.Errorf("Expected ChecksumError, got %v", err)
+	}
+
+	s.config.GeoIP.DBIPExpectedSHA256 = ""
+	s.config.GeoIP.DBIPChecksumURL = checksumServer.URL + "/dbip-country-lite-{YYYY-MM}.mmdb.gz.sha256"
+	if err := fetchFrom(t, s, "dbip"); err != nil {
+		t.Errorf("Expected checksum from URL to match, got %v", err)
+	}
+}
+
+func TestParseChecksum(t *testing.T) {
+	digest := strings.Repeat("ab", 32)
+
+	testCases := []struct {
+		input string
+		valid bool
+	}{
+		{digest, true},
+		{digest + "  GeoLite2-Country_20250101.tar.gz\n", true},
+		{strings.ToUpper(digest), true},
+		{"", false},
+		{"not-a-digest", false},
+		{digest[:32], false},
+	}
+
+	for _, tc := range testCases {
+		_, err := parseChecksum(tc.input)
+		if (err == nil) != tc.valid {
+			t.Errorf("parseChecksum(%q): expected valid=%v, got error %v", tc.input, tc.valid, err)
+		}
+	}
+}
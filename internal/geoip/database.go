@@ -0,0 +1,335 @@
+/*
+ * Copyright (C) 2025  GeorgH93
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package geoip
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"micro_geoip/internal/config"
+	"micro_geoip/internal/logging"
+	"micro_geoip/internal/metrics"
+
+	"github.com/oschwald/geoip2-golang"
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// ErrDatabaseUnavailable is returned by lookups when the database they need
+// is not configured or not loaded (yet)
+var ErrDatabaseUnavailable = errors.New("database not available")
+
+// edition describes a kind of database the service can manage
+type edition struct {
+	name      string // Used in logs, e.g. "country"
+	typeMatch string // Part of the mmdb database type, e.g. "Country"
+
+	// canary looks up ip in a new database and reports whether it found data
+	canary func(reader *maxminddb.Reader, ip net.IP) (bool, error)
+
+	// settings returns the configuration of the database
+	settings func(cfg *config.Config) databaseSettings
+}
+
+var (
+	countryEdition = edition{name: "country", typeMatch: "Country", canary: countryCanary, settings: countrySettings}
+	cityEdition    = edition{name: "city", typeMatch: "City", canary: countryCanary, settings: citySettings}
+	asnEdition     = edition{name: "ASN", typeMatch: "ASN", canary: asnCanary, settings: asnSettings}
+)
+
+func countryCanary(reader *maxminddb.Reader, ip net.IP) (bool, error) {
+	var record geoip2.Country
+	if err := reader.Lookup(ip, &record); err != nil {
+		return false, err
+	}
+	return record.Country.IsoCode != "", nil
+}
+
+func asnCanary(reader *maxminddb.Reader, ip net.IP) (bool, error) {
+	var record geoip2.ASN
+	if err := reader.Lookup(ip, &record); err != nil {
+		return false, err
+	}
+	return record.AutonomousSystemNumber != 0, nil
+}
+
+// databaseSettings are the configuration values that differ between the
+// databases managed by the service
+type databaseSettings struct {
+	path            string
+	maxMindEdition  string
+	dbipURL         string
+	dbipSHA256      string
+	dbipChecksumURL string
+	providers       []config.ProviderConfig
+}
+
+func countrySettings(cfg *config.Config) databaseSettings {
+	return databaseSettings{
+		path:            cfg.GeoIP.DatabasePath,
+		maxMindEdition:  "GeoLite2-Country",
+		dbipURL:         cfg.GeoIP.DBIPUrl,
+		dbipSHA256:      cfg.GeoIP.DBIPExpectedSHA256,
+		dbipChecksumURL: cfg.GeoIP.DBIPChecksumURL,
+		providers:       cfg.GeoIP.Providers,
+	}
+}
+
+// optionalSettings returns the settings of an additional database like the city or ASN database
+func optionalSettings(dc config.DatabaseConfig) databaseSettings {
+	return databaseSettings{
+		path:           dc.DatabasePath,
+		maxMindEdition: dc.MaxMindEdition,
+		dbipURL:        dc.DBIPUrl,
+		providers:      dc.Providers,
+	}
+}
+
+func citySettings(cfg *config.Config) databaseSettings {
+	return optionalSettings(cfg.GeoIP.City)
+}
+
+func asnSettings(cfg *config.Config) databaseSettings {
+	return optionalSettings(cfg.GeoIP.ASN)
+}
+
+// database is a GeoIP database managed by the service. Each database has its
+// own file, provider chain and download state.
+type database struct {
+	edition    edition
+	path       string
+	reader     readerSlot
+	downloader *Downloader
+	providers  []Provider
+
+	mu         sync.Mutex
+	lastUpdate UpdateStatus
+}
+
+func newDatabase(ctx context.Context, cfg *config.Config, ed edition, settings databaseSettings) (*database, error) {
+	downloader, err := newDownloader(ctx, cfg, settings.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up download client: %w", err)
+	}
+
+	providers, err := newProviders(cfg, settings)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up %s database providers: %w", ed.name, err)
+	}
+
+	return &database{
+		edition:    ed,
+		path:       settings.path,
+		downloader: downloader,
+		providers:  providers,
+	}, nil
+}
+
+// load opens the database file and swaps it in for lookups
+func (d *database) load() error {
+	if _, err := os.Stat(d.path); os.IsNotExist(err) {
+		return fmt.Errorf("database file does not exist: %s", d.path)
+	}
+
+	sum, err := hashFile(d.path)
+	if err != nil {
+		return fmt.Errorf("failed to hash GeoIP database: %w", err)
+	}
+
+	reader, err := maxminddb.Open(d.path)
+	if err != nil {
+		return fmt.Errorf("failed to open GeoIP database: %w", err)
+	}
+
+	handle := newReaderHandle(reader)
+	handle.checksum = hex.EncodeToString(sum)
+	if d.downloader != nil {
+		if state, ok := d.downloader.installedState(); ok {
+			handle.source = state.Source
+		}
+	}
+
+	// Swap in the new database, the old one is closed once in-flight lookups are done
+	buildTime := time.Unix(int64(reader.Metadata.BuildEpoch), 0)
+	d.reader.swap(handle)
+	metrics.SetDatabaseBuildTime(d.edition.name, buildTime)
+	logging.Infof("GeoIP %s database loaded: %s", d.edition.name, d.path)
+	return nil
+}
+
+// download downloads and installs the database from the first provider in
+// the chain that succeeds. It returns ErrNotModified if the installed
+// database is still current.
+func (d *database) download() error {
+	if len(d.providers) == 0 {
+		return fmt.Errorf("no database provider configured")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(d.path), 0755); err != nil {
+		return fmt.Errorf("failed to create %s database directory: %w", d.edition.name, err)
+	}
+
+	var errs []error
+	for i, provider := range d.providers {
+		// Don't fall back to the next provider when shutting down
+		if err := d.downloader.ctx.Err(); err != nil {
+			return err
+		}
+
+		logging.Infof("Downloading GeoIP %s database from %s...", d.edition.name, provider.Name())
+
+		err := d.fetch(provider)
+		if err == nil {
+			logging.Infof("%s GeoIP %s database downloaded and installed successfully", provider.Name(), d.edition.name)
+			return nil
+		}
+		if errors.Is(err, ErrNotModified) {
+			return err
+		}
+
+		logDownloadError(provider.Name()+" download failed", err)
+		errs = append(errs, fmt.Errorf("%s: %w", provider.Name(), err))
+
+		if i < len(d.providers)-1 {
+			logging.Infof("Trying %s fallback...", d.providers[i+1].Name())
+		}
+	}
+
+	return fmt.Errorf("no database source available: %w", errors.Join(errs...))
+}
+
+// fetch installs the database from provider, recording the attempt in the metrics
+func (d *database) fetch(provider Provider) error {
+	metrics.UpdateAttempt.WithLabelValues(d.edition.name, provider.Name()).SetToCurrentTime()
+
+	err := provider.Fetch(d.downloader, d.install)
+	if err == nil || errors.Is(err, ErrNotModified) {
+		metrics.UpdateSuccess.WithLabelValues(d.edition.name, provider.Name()).SetToCurrentTime()
+	}
+
+	status := UpdateStatus{Time: time.Now(), Provider: provider.Name(), Result: "success"}
+	if errors.Is(err, ErrNotModified) {
+		status.Result = "not_modified"
+	} else if err != nil {
+		status.Result = "failed"
+		status.Error = RedactError(err)
+	}
+
+	d.mu.Lock()
+	d.lastUpdate = status
+	d.mu.Unlock()
+
+	return err
+}
+
+// update downloads a new database and loads it. Errors are logged.
+func (d *database) update() error {
+	if err := d.download(); errors.Is(err, ErrNotModified) {
+		logging.Infof("GeoIP %s database is up to date", d.edition.name)
+		return nil
+	} else if err != nil {
+		logDownloadError(fmt.Sprintf("%s database update failed", d.edition.name), err)
+		return fmt.Errorf("%s database update failed: %w", d.edition.name, err)
+	}
+
+	if err := d.load(); err != nil {
+		log.Printf("Failed to reload %s database after update: %v", d.edition.name, err)
+		return fmt.Errorf("failed to reload %s database after update: %w", d.edition.name, err)
+	}
+	return nil
+}
+
+// importFrom imports the database from a single provider and loads it
+func (d *database) importFrom(provider Provider) {
+	err := d.fetch(provider)
+	if errors.Is(err, ErrNotModified) {
+		return
+	} else if err != nil {
+		logDownloadError("Import from "+provider.Name()+" failed", err)
+		return
+	}
+
+	if err := d.load(); err != nil {
+		log.Printf("Failed to reload %s database after import from %s: %v", d.edition.name, provider.Name(), err)
+		return
+	}
+
+	logging.Infof("GeoIP %s database imported from %s", d.edition.name, provider.Name())
+}
+
+// buildTime returns the build time recorded in the loaded database
+func (d *database) buildTime() (time.Time, bool) {
+	db := d.reader.acquire()
+	if db == nil {
+		return time.Time{}, false
+	}
+	defer db.release()
+
+	return time.Unix(int64(db.reader.Metadata.BuildEpoch), 0), true
+}
+
+// status returns the current state of the database
+func (d *database) status() DatabaseStatus {
+	status := DatabaseStatus{Name: d.edition.name, Path: d.path}
+
+	if db := d.reader.acquire(); db != nil {
+		metadata := db.reader.Metadata
+		status.Loaded = true
+		status.Type = metadata.DatabaseType
+		status.BuildTime = time.Unix(int64(metadata.BuildEpoch), 0)
+		status.IPVersion = metadata.IPVersion
+		status.Languages = metadata.Languages
+		status.NodeCount = metadata.NodeCount
+		status.Description = metadata.Description
+		status.Checksum = db.checksum
+		status.Source = db.source
+		db.release()
+	} else if d.downloader != nil {
+		if state, ok := d.downloader.installedState(); ok {
+			status.Source = state.Source
+		}
+	}
+
+	d.mu.Lock()
+	status.LastUpdate = d.lastUpdate
+	d.mu.Unlock()
+
+	return status
+}
+
+// close unloads the database, it is closed once in-flight lookups are done
+func (d *database) close() {
+	d.reader.swap(nil)
+	metrics.DeleteDatabase(d.edition.name)
+}
+
+// acquire returns the loaded reader for a lookup, see readerSlot.acquire
+func (d *database) acquire() (*readerHandle, error) {
+	db := d.reader.acquire()
+	if db == nil {
+		return nil, fmt.Errorf("GeoIP %s %w", d.edition.name, ErrDatabaseUnavailable)
+	}
+	return db, nil
+}
@@ -0,0 +1,192 @@
+/*
+ * Copyright (C) 2025  GeorgH93
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package geoip
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+)
+
+// writeTestCityDatabase writes a small GeoLite2-City style database to path
+func writeTestCityDatabase(t testing.TB, path string) {
+	t.Helper()
+
+	writeDatabase(t, path, "GeoLite2-City", map[string]mmdbtype.Map{
+		"8.8.8.0/24": {
+			"country": mmdbtype.Map{
+				"iso_code": mmdbtype.String("US"),
+				"names":    mmdbtype.Map{"en": mmdbtype.String("United States")},
+			},
+			"city": mmdbtype.Map{
+				"names": mmdbtype.Map{"en": mmdbtype.String("Mountain View")},
+			},
+			"subdivisions": mmdbtype.Slice{
+				mmdbtype.Map{
+					"iso_code": mmdbtype.String("CA"),
+					"names":    mmdbtype.Map{"en": mmdbtype.String("California")},
+				},
+			},
+			"postal": mmdbtype.Map{"code": mmdbtype.String("94035")},
+			"location": mmdbtype.Map{
+				"latitude":        mmdbtype.Float64(37.386),
+				"longitude":       mmdbtype.Float64(-122.0838),
+				"accuracy_radius": mmdbtype.Uint16(1000),
+				"time_zone":       mmdbtype.String("America/Los_Angeles"),
+			},
+		},
+	})
+}
+
+// writeTestASNDatabase writes a small GeoLite2-ASN style database to path
+func writeTestASNDatabase(t testing.TB, path string) {
+	t.Helper()
+
+	writeDatabase(t, path, "GeoLite2-ASN", map[string]mmdbtype.Map{
+		"8.8.8.0/24": {
+			"autonomous_system_number":       mmdbtype.Uint32(15169),
+			"autonomous_system_organization": mmdbtype.String("GOOGLE"),
+		},
+		"1.1.1.0/24": {
+			"autonomous_system_number":       mmdbtype.Uint32(13335),
+			"autonomous_system_organization": mmdbtype.String("CLOUDFLARENET"),
+		},
+	})
+}
+
+// addTestCityDatabase adds a loaded city database to the service
+func addTestCityDatabase(t testing.TB, s *Service) {
+	t.Helper()
+
+	s.config.GeoIP.City.DatabasePath = filepath.Join(t.TempDir(), "GeoLite2-City.mmdb")
+	writeTestCityDatabase(t, s.config.GeoIP.City.DatabasePath)
+
+	city, err := newDatabase(s.ctx, s.config, cityEdition, optionalSettings(s.config.GeoIP.City))
+	if err != nil {
+		t.Fatalf("Failed to create city database: %v", err)
+	}
+	if err := city.load(); err != nil {
+		t.Fatalf("Failed to load city database: %v", err)
+	}
+	s.city = city
+}
+
+func TestGetLocation(t *testing.T) {
+	s := newTestService(t)
+	addTestCityDatabase(t, s)
+
+	location, err := s.GetLocation("8.8.8.8")
+	if err != nil {
+		t.Fatalf("GetLocation failed: %v", err)
+	}
+
+	if location.Code != "US" || location.City != "Mountain View" {
+		t.Errorf("Expected Mountain View, US, got %s, %s", location.City, location.Code)
+	}
+	if location.Subdivision != "California" || location.SubdivisionCode != "US-CA" {
+		t.Errorf("Expected California (US-CA), got %s (%s)", location.Subdivision, location.SubdivisionCode)
+	}
+	if location.Latitude != 37.386 || location.Longitude != -122.0838 || location.AccuracyRadius != 1000 {
+		t.Errorf("Unexpected coordinates: %v, %v (%d km)", location.Latitude, location.Longitude, location.AccuracyRadius)
+	}
+	if location.PostalCode != "94035" || location.TimeZone != "America/Los_Angeles" {
+		t.Errorf("Unexpected postal code or time zone: %s, %s", location.PostalCode, location.TimeZone)
+	}
+}
+
+func TestGetLocationWithoutCityDatabase(t *testing.T) {
+	s := newTestService(t)
+
+	if _, err := s.GetLocation("8.8.8.8"); !errors.Is(err, ErrDatabaseUnavailable) {
+		t.Errorf("Expected ErrDatabaseUnavailable, got %v", err)
+	}
+
+	// Country lookups keep working without the city database
+	if _, err := s.GetCountry("8.8.8.8"); err != nil {
+		t.Errorf("GetCountry failed: %v", err)
+	}
+}
+
+func TestGetASN(t *testing.T) {
+	s := newTestService(t)
+
+	if _, err := s.GetASN("8.8.8.8"); !errors.Is(err, ErrDatabaseUnavailable) {
+		t.Errorf("Expected ErrDatabaseUnavailable without ASN database, got %v", err)
+	}
+
+	s.config.GeoIP.ASN.DatabasePath = filepath.Join(t.TempDir(), "GeoLite2-ASN.mmdb")
+	writeTestASNDatabase(t, s.config.GeoIP.ASN.DatabasePath)
+
+	asn, err := newDatabase(s.ctx, s.config, asnEdition, optionalSettings(s.config.GeoIP.ASN))
+	if err != nil {
+		t.Fatalf("Failed to create ASN database: %v", err)
+	}
+	if err := asn.load(); err != nil {
+		t.Fatalf("Failed to load ASN database: %v", err)
+	}
+	s.asn = asn
+
+	asnInfo, err := s.GetASN("1.1.1.1")
+	if err != nil {
+		t.Fatalf("GetASN failed: %v", err)
+	}
+	if asnInfo.Number != 13335 || asnInfo.Organization != "CLOUDFLARENET" {
+		t.Errorf("Expected AS13335 CLOUDFLARENET, got AS%d %s", asnInfo.Number, asnInfo.Organization)
+	}
+	if asnInfo.Network.String() != "1.1.1.0/24" {
+		t.Errorf("Expected network 1.1.1.0/24, got %s", asnInfo.Network)
+	}
+
+	// Addresses without data return an empty result
+	asnInfo, err = s.GetASN("192.0.2.1")
+	if err != nil {
+		t.Fatalf("GetASN failed: %v", err)
+	}
+	if asnInfo.Number != 0 {
+		t.Errorf("Expected no ASN for unannounced address, got %d", asnInfo.Number)
+	}
+}
+
+func TestValidateDatabaseEdition(t *testing.T) {
+	dir := t.TempDir()
+	countryPath := filepath.Join(dir, "GeoLite2-Country.mmdb")
+	cityPath := filepath.Join(dir, "GeoLite2-City.mmdb")
+	writeTestDatabase(t, countryPath)
+	writeTestCityDatabase(t, cityPath)
+
+	if err := validateDatabase(cityPath, cityEdition); err != nil {
+		t.Errorf("Expected city database to be valid: %v", err)
+	}
+	if err := validateDatabase(countryPath, cityEdition); err == nil {
+		t.Error("Expected country database to be rejected as city database")
+	}
+	if err := validateDatabase(cityPath, countryEdition); err == nil {
+		t.Error("Expected city database to be rejected as country database")
+	}
+
+	asnPath := filepath.Join(dir, "GeoLite2-ASN.mmdb")
+	writeTestASNDatabase(t, asnPath)
+	if err := validateDatabase(asnPath, asnEdition); err != nil {
+		t.Errorf("Expected ASN database to be valid: %v", err)
+	}
+	if err := validateDatabase(countryPath, asnEdition); err == nil {
+		t.Error("Expected country database to be rejected as ASN database")
+	}
+}
@@ -0,0 +1,436 @@
+/*
+ * Copyright (C) 2025  GeorgH93
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package geoip
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"micro_geoip/internal/config"
+	"micro_geoip/internal/metrics"
+)
+
+// ErrNotModified is returned by providers when the installed database is
+// still the current one
+var ErrNotModified = errors.New("database not modified since last download")
+
+// redactedParams are query parameters that carry credentials
+var redactedParams = []string{"license_key", "api_key", "key", "token"}
+
+// downloadState records the cache validators of a download, so following
+// requests can be made conditional or resume an interrupted transfer
+type downloadState struct {
+	Source       string `json:"source"`
+	URL          string `json:"url"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+}
+
+// Download is a retrieved database file waiting to be verified and extracted
+type Download struct {
+	Path   string // Location of the downloaded file
+	SHA256 []byte // SHA-256 digest of the downloaded file
+	state  downloadState
+	local  bool
+}
+
+// Discard removes the downloaded file and its resume state. Files provided
+// by Local are left untouched.
+func (d *Download) Discard() {
+	if d.local {
+		return
+	}
+	os.Remove(d.Path)
+	os.Remove(d.Path + ".json")
+}
+
+// Downloader retrieves database files for providers. It keeps the cache
+// validators of the installed database next to it, so unchanged databases
+// aren't downloaded again, and resumes interrupted transfers.
+type Downloader struct {
+	ctx    context.Context // Cancelled on shutdown, aborting running downloads
+	config *config.Config
+	client *http.Client
+	path   string // Path of the database the downloads are for
+}
+
+func newDownloader(ctx context.Context, cfg *config.Config, path string) (*Downloader, error) {
+	client, err := newHTTPClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Downloader{ctx: ctx, config: cfg, client: client, path: path}, nil
+}
+
+// Download retrieves downloadURL into a partial file next to the database.
+// The request is conditional if the installed database came from the same
+// URL, and a previously interrupted transfer of the same file is resumed.
+// It returns ErrNotModified if the installed database is still current.
+func (dl *Downloader) Download(source, downloadURL string, header http.Header) (*Download, error) {
+	var d *Download
+	err := dl.withRetries(source+" download", func() error {
+		var err error
+		d, err = dl.downloadOnce(source, downloadURL, header)
+		return err
+	})
+
+	return d, err
+}
+
+// downloadOnce makes a single download attempt, see Download
+func (dl *Downloader) downloadOnce(source, downloadURL string, header http.Header) (*Download, error) {
+	partPath := dl.partialPath(source)
+	state := downloadState{Source: source, URL: redactURL(downloadURL)}
+
+	req, err := dl.newRequest(downloadURL)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	// Only ask for changes if the installed database is the one we downloaded last
+	if previous, ok := dl.installedState(); ok && previous.URL == state.URL {
+		if previous.ETag != "" {
+			req.Header.Set("If-None-Match", previous.ETag)
+		}
+		if previous.LastModified != "" {
+			req.Header.Set("If-Modified-Since", previous.LastModified)
+		}
+	}
+
+	// Resume an interrupted transfer if the server can confirm it's still the same file
+	var offset int64
+	if partial, err := readDownloadState(partPath + ".json"); err == nil && partial.URL == state.URL {
+		validator := partial.ETag
+		if validator == "" {
+			validator = partial.LastModified
+		}
+		if info, err := os.Stat(partPath); err == nil && info.Size() > 0 && validator != "" {
+			offset = info.Size()
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+			req.Header.Set("If-Range", validator)
+		}
+	}
+
+	start := time.Now()
+	resp, err := dl.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	flags := os.O_CREATE | os.O_WRONLY
+	switch {
+	case resp.StatusCode == http.StatusNotModified:
+		return nil, ErrNotModified
+	case resp.StatusCode == http.StatusPartialContent && offset > 0 && contentRangeStart(resp) == offset:
+		flags |= os.O_APPEND
+	case resp.StatusCode == http.StatusOK:
+		// Full response, the partial file (if any) is outdated
+		offset = 0
+		flags |= os.O_TRUNC
+	default:
+		os.Remove(partPath)
+		os.Remove(partPath + ".json")
+		return nil, &statusError{source: source, code: resp.StatusCode}
+	}
+
+	state.ETag = resp.Header.Get("ETag")
+	state.LastModified = resp.Header.Get("Last-Modified")
+	if err := writeDownloadState(partPath+".json", state); err != nil {
+		return nil, fmt.Errorf("failed to save download state: %w", err)
+	}
+
+	file, err := os.OpenFile(partPath, flags, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create download file: %w", err)
+	}
+
+	written, err := io.Copy(file, resp.Body)
+	metrics.DownloadBytes.WithLabelValues(source).Add(float64(written))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		// Keep the partial file, the next attempt resumes from where this one stopped
+		return nil, fmt.Errorf("download interrupted after %d bytes: %w", offset+written, err)
+	}
+	metrics.DownloadDuration.WithLabelValues(source).Observe(time.Since(start).Seconds())
+
+	sum, err := hashFile(partPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash downloaded file: %w", err)
+	}
+
+	return &Download{Path: partPath, SHA256: sum, state: state}, nil
+}
+
+// Local returns a Download for a file that is already on disk. It returns
+// ErrNotModified if the installed database was taken from the same,
+// unchanged file.
+func (dl *Downloader) Local(source, path string) (*Download, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	state := downloadState{
+		Source:       source,
+		URL:          "file://" + path,
+		ETag:         fmt.Sprintf("%x-%x", info.Size(), info.ModTime().UnixNano()),
+		LastModified: info.ModTime().UTC().Format(http.TimeFormat),
+	}
+	if previous, ok := dl.installedState(); ok && previous == state {
+		return nil, ErrNotModified
+	}
+
+	sum, err := hashFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash %s: %w", path, err)
+	}
+
+	return &Download{Path: path, SHA256: sum, state: state, local: true}, nil
+}
+
+// Checksum downloads a checksum file and returns the digest it contains
+func (dl *Downloader) Checksum(source, checksumURL string) (string, error) {
+	var digest string
+	err := dl.withRetries(source+" checksum download", func() error {
+		req, err := dl.newRequest(checksumURL)
+		if err != nil {
+			return err
+		}
+
+		resp, err := dl.client.Do(req)
+		if err != nil {
+			return fmt.Errorf("failed to download checksum: %w", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return &statusError{source: source + " checksum", code: resp.StatusCode}
+		}
+
+		// Checksum files are tiny, anything larger is not what we asked for
+		data, err := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		if err != nil {
+			return fmt.Errorf("failed to read checksum: %w", err)
+		}
+
+		digest, err = parseChecksum(string(data))
+		return err
+	})
+
+	return digest, err
+}
+
+// Commit remembers the validators of a download after it has been installed,
+// so the next update can be skipped if nothing changed
+func (dl *Downloader) Commit(d *Download) error {
+	return writeDownloadState(dl.statePath(), d.state)
+}
+
+// installedState returns the validators of the installed database, if it
+// is still in place
+func (dl *Downloader) installedState() (downloadState, bool) {
+	if _, err := os.Stat(dl.path); err != nil {
+		return downloadState{}, false
+	}
+
+	state, err := readDownloadState(dl.statePath())
+	return state, err == nil
+}
+
+// partialPath returns where downloads from source are stored until they are installed
+func (dl *Downloader) partialPath(source string) string {
+	name := strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '-' {
+			return r
+		}
+		return '_'
+	}, strings.ToLower(source))
+
+	return dl.path + "." + name + ".part"
+}
+
+// statePath returns where the validators of the installed database are kept
+func (dl *Downloader) statePath() string {
+	return dl.path + ".download.json"
+}
+
+// newRequest creates a GET request carrying the configured User-Agent
+func (dl *Downloader) newRequest(rawURL string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(dl.ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if userAgent := dl.config.GeoIP.Download.UserAgent; userAgent != "" {
+		req.Header.Set("User-Agent", userAgent)
+	}
+
+	return req, nil
+}
+
+// withRetries runs attempt until it succeeds, fails permanently or the
+// configured number of retries is used up, backing off exponentially
+func (dl *Downloader) withRetries(name string, attempt func() error) error {
+	backoff, err := time.ParseDuration(dl.config.GeoIP.Download.RetryBackoff)
+	if err != nil || backoff <= 0 {
+		backoff = time.Second
+	}
+
+	retries := dl.config.GeoIP.Download.Retries
+	for retry := 0; ; retry++ {
+		err := attempt()
+		if err == nil || !retryable(err) || retry >= retries || dl.ctx.Err() != nil {
+			return err
+		}
+
+		log.Printf("%s failed (attempt %d of %d): %v, retrying in %s", name, retry+1, retries+1, err, backoff)
+		if !sleepContext(dl.ctx, backoff) {
+			return err
+		}
+
+		backoff = min(backoff*2, maxRetryBackoff)
+	}
+}
+
+func readDownloadState(path string) (downloadState, error) {
+	var state downloadState
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return state, err
+	}
+
+	err = json.Unmarshal(data, &state)
+	return state, err
+}
+
+func writeDownloadState(path string, state downloadState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0644)
+}
+
+// sleepContext waits for d, it returns false if ctx is cancelled before
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// contentRangeStart returns the first byte position of a 206 response, or -1
+func contentRangeStart(resp *http.Response) int64 {
+	var start, end int64
+	if _, err := fmt.Sscanf(resp.Header.Get("Content-Range"), "bytes %d-%d/", &start, &end); err != nil {
+		return -1
+	}
+	return start
+}
+
+func hashFile(path string) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return nil, err
+	}
+
+	return hash.Sum(nil), nil
+}
+
+// redactURL removes credentials from a download URL, so it can be logged and stored
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+
+	query := u.Query()
+	for _, param := range redactedParams {
+		if query.Has(param) {
+			query.Set(param, "REDACTED")
+		}
+	}
+	u.RawQuery = query.Encode()
+	if u.User != nil {
+		u.User = url.User("REDACTED")
+	}
+
+	return u.String()
+}
+
+// RedactError returns the message of err with credentials removed from the
+// request URLs it may contain, so it can be shown to API clients
+func RedactError(err error) string {
+	msg := err.Error()
+	for _, urlErr := range urlErrors(err) {
+		msg = strings.ReplaceAll(msg, urlErr.URL, redactURL(urlErr.URL))
+	}
+	return msg
+}
+
+// urlErrors returns all url.Errors in the tree of err, a failed update may
+// contain one for each provider
+func urlErrors(err error) []*url.Error {
+	if urlErr, ok := err.(*url.Error); ok {
+		return []*url.Error{urlErr}
+	}
+
+	switch wrapped := err.(type) {
+	case interface{ Unwrap() error }:
+		if inner := wrapped.Unwrap(); inner != nil {
+			return urlErrors(inner)
+		}
+	case interface{ Unwrap() []error }:
+		var urlErrs []*url.Error
+		for _, inner := range wrapped.Unwrap() {
+			urlErrs = append(urlErrs, urlErrors(inner)...)
+		}
+		return urlErrs
+	}
+	return nil
+}
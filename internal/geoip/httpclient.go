@@ -0,0 +1,132 @@
+/*
+ * Copyright (C) 2025  GeorgH93
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package geoip
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"micro_geoip/internal/config"
+)
+
+// maxRetryBackoff caps the exponential backoff between download attempts
+const maxRetryBackoff = 5 * time.Minute
+
+// statusError is returned when a provider answers with an unexpected HTTP status
+type statusError struct {
+	source string
+	code   int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("%s download failed with status: %d", e.source, e.code)
+}
+
+// newHTTPClient builds the client used for all database downloads from the
+// geoip.download configuration
+func newHTTPClient(cfg *config.Config) (*http.Client, error) {
+	settings := cfg.GeoIP.Download
+
+	connectTimeout, err := parseDuration("connect timeout", settings.ConnectTimeout)
+	if err != nil {
+		return nil, err
+	}
+	timeout, err := parseDuration("timeout", settings.Timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{
+		Timeout:   connectTimeout,
+		KeepAlive: 30 * time.Second,
+	}).DialContext
+	transport.TLSHandshakeTimeout = connectTimeout
+
+	if settings.ProxyURL != "" {
+		proxyURL, err := url.Parse(settings.ProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid download proxy URL: %w", err)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	if settings.CABundle != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+
+		pem, err := os.ReadFile(settings.CABundle)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA bundle: %s", settings.CABundle)
+		}
+
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	}
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   timeout,
+	}, nil
+}
+
+// parseDuration parses an optional duration setting of the download client,
+// zero disables the corresponding timeout
+func parseDuration(name, value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid download %s '%s': %w", name, value, err)
+	}
+
+	return duration, nil
+}
+
+// retryable reports whether a failed download may succeed when tried again
+func retryable(err error) bool {
+	if errors.Is(err, ErrNotModified) {
+		return false
+	}
+
+	var checksumErr *ChecksumError
+	if errors.As(err, &checksumErr) {
+		return false
+	}
+
+	var statusErr *statusError
+	if errors.As(err, &statusErr) {
+		return statusErr.code >= 500 || statusErr.code == http.StatusTooManyRequests
+	}
+
+	// Network errors and interrupted transfers
+	return true
+}
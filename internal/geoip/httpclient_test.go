@@ -0,0 +1,177 @@
+/*
+ * Copyright (C) 2025  GeorgH93
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package geoip
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDownloadRetriesWithUserAgent(t *testing.T) {
+	s := newTestService(t)
+	data := gzipFile(t, s.config.GeoIP.DatabasePath)
+
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ua := r.Header.Get("User-Agent"); ua != "micro_geoip-test" {
+			t.Errorf("Expected User-Agent micro_geoip-test, got %q", ua)
+		}
+		if requests.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write(data)
+	}))
+	defer server.Close()
+
+	s.config.GeoIP.DBIPUrl = server.URL + "/dbip.mmdb.gz"
+	s.config.GeoIP.Download.UserAgent = "micro_geoip-test"
+	s.config.GeoIP.Download.Retries = 2
+	s.config.GeoIP.Download.RetryBackoff = "1ms"
+
+	if err := fetchFrom(t, s, "dbip"); err != nil {
+		t.Fatalf("Expected download to succeed after retries, got %v", err)
+	}
+	if n := requests.Load(); n != 3 {
+		t.Errorf("Expected 3 requests, got %d", n)
+	}
+}
+
+func TestDownloadDoesNotRetryClientErrors(t *testing.T) {
+	s := newTestService(t)
+
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	s.config.GeoIP.DBIPUrl = server.URL + "/dbip.mmdb.gz"
+	s.config.GeoIP.Download.Retries = 3
+	s.config.GeoIP.Download.RetryBackoff = "1ms"
+
+	if err := fetchFrom(t, s, "dbip"); err == nil {
+		t.Fatal("Expected download to fail")
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("Expected a single request for a 404, got %d", n)
+	}
+}
+
+func TestDownloadThroughProxy(t *testing.T) {
+	s := newTestService(t)
+	data := gzipFile(t, s.config.GeoIP.DatabasePath)
+
+	var proxiedHost atomic.Value
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxiedHost.Store(r.URL.Host)
+		w.Write(data)
+	}))
+	defer proxy.Close()
+
+	s.config.GeoIP.DBIPUrl = "http://downloads.example.com/dbip.mmdb.gz"
+	s.config.GeoIP.Download.ProxyURL = proxy.URL
+
+	if err := fetchFrom(t, s, "dbip"); err != nil {
+		t.Fatalf("Download through proxy failed: %v", err)
+	}
+	if host, _ := proxiedHost.Load().(string); host != "downloads.example.com" {
+		t.Errorf("Expected proxy to receive request for downloads.example.com, got %q", host)
+	}
+}
+
+func TestDownloadWithCABundle(t *testing.T) {
+	s := newTestService(t)
+	data := gzipFile(t, s.config.GeoIP.DatabasePath)
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(data)
+	}))
+	defer server.Close()
+
+	s.config.GeoIP.DBIPUrl = server.URL + "/dbip.mmdb.gz"
+
+	// The test server's certificate is not trusted by default
+	if err := fetchFrom(t, s, "dbip"); err == nil {
+		t.Fatal("Expected download from untrusted server to fail")
+	}
+
+	bundle := filepath.Join(t.TempDir(), "ca.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(bundle, certPEM, 0644); err != nil {
+		t.Fatal(err)
+	}
+	s.config.GeoIP.Download.CABundle = bundle
+
+	if err := fetchFrom(t, s, "dbip"); err != nil {
+		t.Fatalf("Download with CA bundle failed: %v", err)
+	}
+}
+
+func TestDownloadTimeout(t *testing.T) {
+	s := newTestService(t)
+
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	s.config.GeoIP.DBIPUrl = server.URL + "/dbip.mmdb.gz"
+	s.config.GeoIP.Download.Timeout = "50ms"
+
+	done := make(chan error, 1)
+	go func() { done <- fetchFrom(t, s, "dbip") }()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("Expected stalled download to fail")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stalled download did not time out")
+	}
+}
+
+func TestNewHTTPClientInvalidSettings(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.GeoIP.Download.Timeout = "soon"
+	if _, err := newHTTPClient(cfg); err == nil {
+		t.Error("Expected invalid timeout to be rejected")
+	}
+
+	cfg = newTestConfig()
+	cfg.GeoIP.Download.CABundle = filepath.Join(t.TempDir(), "missing.pem")
+	if _, err := newHTTPClient(cfg); err == nil {
+		t.Error("Expected missing CA bundle to be rejected")
+	}
+
+	cfg = newTestConfig()
+	cfg.GeoIP.Download.ProxyURL = "://invalid"
+	if _, err := newHTTPClient(cfg); err == nil {
+		t.Error("Expected invalid proxy URL to be rejected")
+	}
+}
@@ -0,0 +1,172 @@
+/*
+ * Copyright (C) 2025  GeorgH93
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package geoip
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// minBuildEpoch is the oldest build date accepted for a downloaded database
+var minBuildEpoch = time.Date(2015, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// canaryIPs are looked up in every new database before it is installed
+var canaryIPs = []string{"8.8.8.8", "1.1.1.1", "2001:4860:4860::8888"}
+
+// install writes the database read from src to a temporary file next to the
+// database path, validates it and then renames it into place. The previously
+// installed database is kept as a .bak file.
+func (d *database) install(src io.Reader) error {
+	dbPath := d.path
+
+	tmpFile, err := os.CreateTemp(filepath.Dir(dbPath), "."+filepath.Base(dbPath)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary database file: %w", err)
+	}
+	tmpPath := tmpFile.Name()
+	// Removing fails harmlessly once the file has been renamed into place
+	defer os.Remove(tmpPath)
+
+	if _, err := io.Copy(tmpFile, src); err != nil {
+		tmpFile.Close()
+		return fmt.Errorf("failed to write database: %w", err)
+	}
+	if err := tmpFile.Sync(); err != nil {
+		tmpFile.Close()
+		return fmt.Errorf("failed to sync database: %w", err)
+	}
+	if err := tmpFile.Close(); err != nil {
+		return fmt.Errorf("failed to close database: %w", err)
+	}
+
+	if err := validateDatabase(tmpPath, d.edition); err != nil {
+		return fmt.Errorf("downloaded database is invalid: %w", err)
+	}
+
+	if err := backupDatabase(dbPath); err != nil {
+		log.Printf("Failed to back up previous database: %v", err)
+	}
+
+	if err := os.Rename(tmpPath, dbPath); err != nil {
+		return fmt.Errorf("failed to move database into place: %w", err)
+	}
+
+	return nil
+}
+
+// validateDatabase checks that the file at path is a usable database of the given edition
+func validateDatabase(path string, ed edition) error {
+	reader, err := maxminddb.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer reader.Close()
+
+	metadata := reader.Metadata
+	if !strings.Contains(metadata.DatabaseType, ed.typeMatch) {
+		return fmt.Errorf("unexpected database type: %s", metadata.DatabaseType)
+	}
+
+	buildTime := time.Unix(int64(metadata.BuildEpoch), 0)
+	if buildTime.Before(minBuildEpoch) || buildTime.After(time.Now().Add(24*time.Hour)) {
+		return fmt.Errorf("implausible build time: %s", buildTime.UTC().Format(time.RFC3339))
+	}
+
+	if metadata.NodeCount == 0 {
+		return fmt.Errorf("database contains no records")
+	}
+
+	resolved := 0
+	for _, ip := range databaseCanaryIPs(reader) {
+		found, err := ed.canary(reader, net.ParseIP(ip))
+		if err != nil {
+			return fmt.Errorf("canary lookup for %s failed: %w", ip, err)
+		}
+		if found {
+			resolved++
+		}
+	}
+	if resolved == 0 {
+		return fmt.Errorf("canary lookups returned no data")
+	}
+
+	return nil
+}
+
+// databaseCanaryIPs returns the canary IPs that can be looked up in a
+// database, IPv4-only databases reject IPv6 lookups
+func databaseCanaryIPs(reader *maxminddb.Reader) []string {
+	if reader.Metadata.IPVersion != 4 {
+		return canaryIPs
+	}
+
+	var ips []string
+	for _, ip := range canaryIPs {
+		if net.ParseIP(ip).To4() != nil {
+			ips = append(ips, ip)
+		}
+	}
+	return ips
+}
+
+// backupDatabase keeps the currently installed database as path.bak
+func backupDatabase(path string) error {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil
+	}
+
+	backupPath := path + ".bak"
+	if err := os.Remove(backupPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	// A hard link keeps the live file in place until the rename replaces it
+	if err := os.Link(path, backupPath); err == nil {
+		return nil
+	}
+
+	return copyFile(path, backupPath)
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+
+	return out.Close()
+}
@@ -0,0 +1,153 @@
+/*
+ * Copyright (C) 2025  GeorgH93
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package geoip
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/maxmind/mmdbwriter"
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+)
+
+func TestInstallDatabase(t *testing.T) {
+	s := newTestService(t)
+	dbPath := s.config.GeoIP.DatabasePath
+
+	previous, err := os.ReadFile(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newPath := filepath.Join(t.TempDir(), "new.mmdb")
+	writeTestDatabase(t, newPath)
+	newFile, err := os.Open(newPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer newFile.Close()
+
+	if err := s.country.install(newFile); err != nil {
+		t.Fatalf("installDatabase failed: %v", err)
+	}
+
+	backup, err := os.ReadFile(dbPath + ".bak")
+	if err != nil {
+		t.Fatalf("Expected backup of previous database: %v", err)
+	}
+	if !bytes.Equal(backup, previous) {
+		t.Error("Backup does not match the previous database")
+	}
+
+	if err := validateDatabase(dbPath, countryEdition); err != nil {
+		t.Errorf("Installed database is invalid: %v", err)
+	}
+
+	assertNoTempFiles(t, filepath.Dir(dbPath))
+}
+
+func TestInstallDatabaseRejectsInvalidData(t *testing.T) {
+	s := newTestService(t)
+	dbPath := s.config.GeoIP.DatabasePath
+
+	previous, err := os.ReadFile(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A truncated download must never replace the installed database
+	truncated := bytes.NewReader(previous[:len(previous)/2])
+	if err := s.country.install(truncated); err == nil {
+		t.Fatal("Expected truncated database to be rejected")
+	}
+
+	current, err := os.ReadFile(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(current, previous) {
+		t.Error("Installed database was modified by a failed install")
+	}
+
+	if _, err := os.Stat(dbPath + ".bak"); !os.IsNotExist(err) {
+		t.Error("Expected no backup to be created for a failed install")
+	}
+
+	assertNoTempFiles(t, filepath.Dir(dbPath))
+}
+
+// writeIPv4TestDatabase writes a small GeoLite2-Country style database
+// without IPv6 networks to path
+func writeIPv4TestDatabase(t testing.TB, path string) {
+	t.Helper()
+
+	writer, err := mmdbwriter.New(mmdbwriter.Options{
+		DatabaseType: "GeoLite2-Country",
+		BuildEpoch:   time.Now().Unix(),
+		IPVersion:    4,
+		RecordSize:   24,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create database writer: %v", err)
+	}
+
+	_, network, _ := net.ParseCIDR("8.8.8.0/24")
+	record := mmdbtype.Map{"country": mmdbtype.Map{"iso_code": mmdbtype.String("US")}}
+	if err := writer.Insert(network, record); err != nil {
+		t.Fatalf("Failed to insert %s: %v", network, err)
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Failed to create database file: %v", err)
+	}
+	defer file.Close()
+
+	if _, err := writer.WriteTo(file); err != nil {
+		t.Fatalf("Failed to write database: %v", err)
+	}
+}
+
+func TestValidateIPv4OnlyDatabase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ipv4.mmdb")
+	writeIPv4TestDatabase(t, path)
+
+	// The IPv6 canary must not reject the database
+	if err := validateDatabase(path, countryEdition); err != nil {
+		t.Errorf("Expected IPv4-only database to be valid: %v", err)
+	}
+}
+
+func assertNoTempFiles(t *testing.T, dir string) {
+	t.Helper()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), ".tmp") {
+			t.Errorf("Temporary file left behind: %s", entry.Name())
+		}
+	}
+}
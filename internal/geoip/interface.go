@@ -17,8 +17,26 @@
 
 package geoip
 
+import "net/netip"
+
 // GeoIPService defines the interface for GeoIP lookup services
 type GeoIPService interface {
 	GetCountry(ip string) (*CountryInfo, error)
+	// GetLocation returns city level information. It returns an error
+	// wrapping ErrDatabaseUnavailable if no city database is loaded.
+	GetLocation(ip string) (*LocationInfo, error)
+	// GetASN returns the autonomous system of an IP address. It returns an
+	// error wrapping ErrDatabaseUnavailable if no ASN database is loaded.
+	GetASN(ip string) (*ASNInfo, error)
+	// CountryNetworks returns the networks assigned to a country by its ISO
+	// code, only IPv4 or IPv6 networks with ipVersion 4 or 6
+	CountryNetworks(code string, ipVersion int) ([]netip.Prefix, error)
+	// Status returns the state of all configured databases, the country
+	// database first
+	Status() []DatabaseStatus
+	// Update downloads and loads new releases of all databases, Reload
+	// re-opens them from disk. Both are serialized with other updates.
+	Update() error
+	Reload() error
 	Close() error
 }
@@ -17,9 +17,32 @@
 
 package geoip
 
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+	"time"
+)
+
 // MockService implements the GeoIP service interface for testing
 type MockService struct {
-	CountryMap map[string]*CountryInfo
+	CountryMap  map[string]*CountryInfo
+	LocationMap map[string]*LocationInfo
+	ASNMap      map[string]*ASNInfo
+	NetworkMap  map[string][]netip.Prefix // Networks by country code
+
+	DatabaseStatuses []DatabaseStatus
+
+	// UpdateErr and ReloadErr are returned by Update and Reload, which
+	// count their calls in Updates and Reloads
+	UpdateErr error
+	ReloadErr error
+	Updates   int
+	Reloads   int
+
+	// Unavailable makes the mock behave like a service that is still
+	// waiting for its initial country database download
+	Unavailable bool
 }
 
 func NewMockService() *MockService {
@@ -35,6 +58,10 @@ func NewMockService() *MockService {
 }
 
 func (m *MockService) GetCountry(ip string) (*CountryInfo, error) {
+	if m.Unavailable {
+		return nil, fmt.Errorf("GeoIP country %w", ErrDatabaseUnavailable)
+	}
+
 	if m.CountryMap == nil {
 		m.CountryMap = make(map[string]*CountryInfo)
 		m.CountryMap["8.8.8.8"] = &CountryInfo{Code: "US", Name: "United States"}
@@ -49,6 +76,76 @@ func (m *MockService) GetCountry(ip string) (*CountryInfo, error) {
 	return &CountryInfo{Code: "Unknown", Name: "Unknown"}, nil
 }
 
+func (m *MockService) GetLocation(ip string) (*LocationInfo, error) {
+	// Without locations the mock behaves like a service without city database
+	if m.LocationMap == nil {
+		return nil, fmt.Errorf("GeoIP city %w", ErrDatabaseUnavailable)
+	}
+
+	if location, exists := m.LocationMap[ip]; exists {
+		return location, nil
+	}
+
+	country, err := m.GetCountry(ip)
+	if err != nil {
+		return nil, err
+	}
+	return &LocationInfo{CountryInfo: *country}, nil
+}
+
+func (m *MockService) GetASN(ip string) (*ASNInfo, error) {
+	// Without ASNs the mock behaves like a service without ASN database
+	if m.ASNMap == nil {
+		return nil, fmt.Errorf("GeoIP ASN %w", ErrDatabaseUnavailable)
+	}
+
+	if asn, exists := m.ASNMap[ip]; exists {
+		return asn, nil
+	}
+
+	return &ASNInfo{}, nil
+}
+
+func (m *MockService) CountryNetworks(code string, ipVersion int) ([]netip.Prefix, error) {
+	if m.Unavailable {
+		return nil, fmt.Errorf("GeoIP country %w", ErrDatabaseUnavailable)
+	}
+
+	return filterNetworks(m.NetworkMap[strings.ToUpper(code)], ipVersion), nil
+}
+
+func (m *MockService) Status() []DatabaseStatus {
+	if m.DatabaseStatuses != nil {
+		return m.DatabaseStatuses
+	}
+
+	// Without statuses the mock behaves like a service with a fresh country database
+	if m.Unavailable {
+		return []DatabaseStatus{{Name: "country", Path: "mock"}}
+	}
+
+	return []DatabaseStatus{{
+		Name:      "country",
+		Loaded:    true,
+		Type:      "GeoLite2-Country",
+		BuildTime: time.Now(),
+		Source:    "mock",
+		Path:      "mock",
+		IPVersion: 6,
+		Languages: []string{"en"},
+	}}
+}
+
+func (m *MockService) Update() error {
+	m.Updates++
+	return m.UpdateErr
+}
+
+func (m *MockService) Reload() error {
+	m.Reloads++
+	return m.ReloadErr
+}
+
 func (m *MockService) Close() error {
 	return nil
 }
@@ -60,6 +157,20 @@ func (m *MockService) SetCountry(ip, code, name string) {
 	m.CountryMap[ip] = &CountryInfo{Code: code, Name: name}
 }
 
+func (m *MockService) SetLocation(ip string, location *LocationInfo) {
+	if m.LocationMap == nil {
+		m.LocationMap = make(map[string]*LocationInfo)
+	}
+	m.LocationMap[ip] = location
+}
+
+func (m *MockService) SetASN(ip string, number uint, organization string) {
+	if m.ASNMap == nil {
+		m.ASNMap = make(map[string]*ASNInfo)
+	}
+	m.ASNMap[ip] = &ASNInfo{Number: number, Organization: organization}
+}
+
 func (m *MockService) AddError(ip string) {
 	if m.CountryMap == nil {
 		m.CountryMap = make(map[string]*CountryInfo)
@@ -0,0 +1,131 @@
+/*
+ * Copyright (C) 2025  GeorgH93
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package geoip
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// CountryNetworks returns the networks the country database assigns to a
+// country by its ISO code, adjacent networks collapsed. ipVersion 4 or 6
+// limits them to IPv4 or IPv6 networks, 0 returns both. The networks of all
+// countries are collected on the first call after a database is loaded,
+// which walks the whole database.
+func (s *Service) CountryNetworks(code string, ipVersion int) ([]netip.Prefix, error) {
+	db, err := s.country.acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer db.release()
+
+	networks, err := db.countryNetworks()
+	if err != nil {
+		return nil, err
+	}
+	return filterNetworks(networks[strings.ToUpper(code)], ipVersion), nil
+}
+
+// countryNetworks returns the collapsed networks of each country in the
+// database, they are collected once per handle
+func (h *readerHandle) countryNetworks() (map[string][]netip.Prefix, error) {
+	h.networksOnce.Do(func() {
+		h.networks, h.networksErr = collectCountryNetworks(h.reader)
+	})
+	return h.networks, h.networksErr
+}
+
+// collectCountryNetworks walks all networks of a database and groups them by
+// country. IPv4 networks are only visited once, not again through the IPv6
+// ranges they are mapped into.
+func collectCountryNetworks(reader *maxminddb.Reader) (map[string][]netip.Prefix, error) {
+	var record struct {
+		Country struct {
+			IsoCode string `maxminddb:"iso_code"`
+		} `maxminddb:"country"`
+	}
+
+	networks := make(map[string][]netip.Prefix)
+	iterator := reader.Networks(maxminddb.SkipAliasedNetworks)
+	for iterator.Next() {
+		record.Country.IsoCode = ""
+		network, err := iterator.Network(&record)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read network: %w", err)
+		}
+		if code := record.Country.IsoCode; code != "" {
+			networks[code] = append(networks[code], networkPrefix(network))
+		}
+	}
+	if err := iterator.Err(); err != nil {
+		return nil, fmt.Errorf("failed to walk networks: %w", err)
+	}
+
+	for code, prefixes := range networks {
+		networks[code] = collapseNetworks(prefixes)
+	}
+	return networks, nil
+}
+
+// collapseNetworks sorts non-overlapping networks and merges adjacent ones
+// into their common parent network wherever possible
+func collapseNetworks(networks []netip.Prefix) []netip.Prefix {
+	slices.SortFunc(networks, func(a, b netip.Prefix) int {
+		return a.Addr().Compare(b.Addr())
+	})
+
+	collapsed := make([]netip.Prefix, 0, len(networks))
+	for _, network := range networks {
+		// Merging two halves can make the result the other half of the
+		// network before it, so keep merging
+		for len(collapsed) > 0 {
+			last := collapsed[len(collapsed)-1]
+			if last.Bits() != network.Bits() || network.Bits() == 0 {
+				break
+			}
+			parent := netip.PrefixFrom(last.Addr(), last.Bits()-1).Masked()
+			if parent != netip.PrefixFrom(network.Addr(), network.Bits()-1).Masked() {
+				break
+			}
+			collapsed = collapsed[:len(collapsed)-1]
+			network = parent
+		}
+		collapsed = append(collapsed, network)
+	}
+
+	return slices.Clip(collapsed)
+}
+
+// filterNetworks returns the networks of an IP version, all for version 0
+func filterNetworks(networks []netip.Prefix, ipVersion int) []netip.Prefix {
+	if ipVersion == 0 {
+		return networks
+	}
+
+	var filtered []netip.Prefix
+	for _, network := range networks {
+		if (ipVersion == 4) == network.Addr().Is4() {
+			filtered = append(filtered, network)
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,137 @@
+/*
+ * Copyright (C) 2025  GeorgH93
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package geoip
+
+import (
+	"errors"
+	"net/netip"
+	"slices"
+	"testing"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+)
+
+func prefixes(networks ...string) []netip.Prefix {
+	var parsed []netip.Prefix
+	for _, network := range networks {
+		parsed = append(parsed, netip.MustParsePrefix(network))
+	}
+	return parsed
+}
+
+func TestCollapseNetworks(t *testing.T) {
+	tests := []struct {
+		name     string
+		networks []netip.Prefix
+		expected []netip.Prefix
+	}{
+		{
+			"siblings",
+			prefixes("10.0.1.0/24", "10.0.0.0/24"),
+			prefixes("10.0.0.0/23"),
+		},
+		{
+			"cascading",
+			prefixes("10.0.0.0/24", "10.0.1.0/25", "10.0.1.128/25", "10.0.2.0/23"),
+			prefixes("10.0.0.0/22"),
+		},
+		{
+			"adjacent but not mergeable",
+			prefixes("10.0.1.0/24", "10.0.2.0/24"),
+			prefixes("10.0.1.0/24", "10.0.2.0/24"),
+		},
+		{
+			"different sizes",
+			prefixes("10.0.0.0/24", "10.0.1.0/25"),
+			prefixes("10.0.0.0/24", "10.0.1.0/25"),
+		},
+		{
+			"mixed families",
+			prefixes("2001:db8:1::/48", "192.0.2.0/25", "2001:db8::/48", "192.0.2.128/25"),
+			prefixes("192.0.2.0/24", "2001:db8::/47"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if collapsed := collapseNetworks(tt.networks); !slices.Equal(collapsed, tt.expected) {
+				t.Errorf("Expected %v, got %v", tt.expected, collapsed)
+			}
+		})
+	}
+}
+
+func TestCountryNetworks(t *testing.T) {
+	s := newTestService(t)
+
+	networks, err := s.CountryNetworks("us", 0)
+	if err != nil {
+		t.Fatalf("CountryNetworks failed: %v", err)
+	}
+	if expected := prefixes("8.8.8.0/24", "2001:4860::/32"); !slices.Equal(networks, expected) {
+		t.Errorf("Expected %v, got %v", expected, networks)
+	}
+
+	networks, _ = s.CountryNetworks("US", 6)
+	if expected := prefixes("2001:4860::/32"); !slices.Equal(networks, expected) {
+		t.Errorf("Expected IPv6 networks %v, got %v", expected, networks)
+	}
+
+	networks, _ = s.CountryNetworks("DE", 4)
+	if expected := prefixes("134.195.196.0/22"); !slices.Equal(networks, expected) {
+		t.Errorf("Expected IPv4 networks %v, got %v", expected, networks)
+	}
+
+	if networks, _ := s.CountryNetworks("FR", 0); len(networks) != 0 {
+		t.Errorf("Expected no networks for a country without any, got %v", networks)
+	}
+}
+
+func TestCountryNetworksCollapsed(t *testing.T) {
+	s := newTestService(t)
+
+	// Databases split networks where other data differs, e.g. the city
+	records := make(map[string]mmdbtype.Map)
+	for network, city := range map[string]string{"8.8.8.0/25": "Mountain View", "8.8.8.128/26": "Palo Alto", "8.8.8.192/26": "Sunnyvale"} {
+		records[network] = mmdbtype.Map{
+			"country": mmdbtype.Map{"iso_code": mmdbtype.String("US")},
+			"city":    mmdbtype.Map{"names": mmdbtype.Map{"en": mmdbtype.String(city)}},
+		}
+	}
+	writeDatabase(t, s.country.path, "GeoLite2-Country", records)
+	if err := s.country.load(); err != nil {
+		t.Fatalf("Failed to load database: %v", err)
+	}
+
+	networks, err := s.CountryNetworks("US", 4)
+	if err != nil {
+		t.Fatalf("CountryNetworks failed: %v", err)
+	}
+	if expected := prefixes("8.8.8.0/24"); !slices.Equal(networks, expected) {
+		t.Errorf("Expected collapsed networks %v, got %v", expected, networks)
+	}
+}
+
+func TestCountryNetworksWithoutDatabase(t *testing.T) {
+	s := newTestService(t)
+	s.country.close()
+
+	if _, err := s.CountryNetworks("US", 0); !errors.Is(err, ErrDatabaseUnavailable) {
+		t.Errorf("Expected ErrDatabaseUnavailable, got %v", err)
+	}
+}
@@ -0,0 +1,221 @@
+/*
+ * Copyright (C) 2025  GeorgH93
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package geoip
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"micro_geoip/internal/config"
+	"micro_geoip/internal/logging"
+)
+
+// Provider is a source the GeoIP database can be obtained from. Providers
+// are tried in the configured order until one of them succeeds.
+type Provider interface {
+	// Name identifies the provider in logs and download state
+	Name() string
+
+	// Fetch retrieves the database using dl and passes the .mmdb contents to
+	// install. It returns ErrNotModified if the installed database is current.
+	Fetch(dl *Downloader, install func(io.Reader) error) error
+}
+
+// watchableProvider is implemented by providers that detect new databases
+// themselves instead of waiting for the update schedule
+type watchableProvider interface {
+	Provider
+
+	// Watch calls notify when a new database may be available, until the
+	// returned closer is closed
+	Watch(notify func()) (io.Closer, error)
+}
+
+// newProviders builds the provider chain of a database. Without an explicit
+// providers list the chain is derived from the MaxMind and DB-IP settings.
+func newProviders(cfg *config.Config, settings databaseSettings) ([]Provider, error) {
+	if len(settings.providers) == 0 {
+		return defaultProviders(cfg, settings), nil
+	}
+
+	providers := make([]Provider, 0, len(settings.providers))
+	for i, pc := range settings.providers {
+		provider, err := newProvider(cfg, settings, pc)
+		if err != nil {
+			return nil, fmt.Errorf("invalid provider %d (%s): %w", i+1, pc.Type, err)
+		}
+		providers = append(providers, provider)
+	}
+
+	return providers, nil
+}
+
+// defaultProviders prefers MaxMind if an API key is available, unless DB-IP
+// is preferred, and falls back to the other source
+func defaultProviders(cfg *config.Config, settings databaseSettings) []Provider {
+	maxMind, _ := newProvider(cfg, settings, config.ProviderConfig{Type: "maxmind"})
+	dbip, _ := newProvider(cfg, settings, config.ProviderConfig{Type: "dbip"})
+
+	if cfg.GeoIP.MaxMindAPIKey == "" {
+		return []Provider{dbip}
+	}
+	if cfg.GeoIP.PreferDBIP {
+		return []Provider{dbip, maxMind}
+	}
+	return []Provider{maxMind, dbip}
+}
+
+// newProvider creates a single provider. Settings missing for the maxmind and
+// dbip types fall back to the corresponding geoip and database settings.
+func newProvider(cfg *config.Config, settings databaseSettings, pc config.ProviderConfig) (Provider, error) {
+	switch strings.ToLower(pc.Type) {
+	case "maxmind":
+		p := &maxMindProvider{
+			name:    firstNonEmpty(pc.Name, "MaxMind"),
+			baseURL: firstNonEmpty(pc.URL, cfg.GeoIP.MaxMindURL),
+			apiKey:  firstNonEmpty(pc.APIKey, cfg.GeoIP.MaxMindAPIKey),
+			edition: firstNonEmpty(pc.Edition, settings.maxMindEdition),
+		}
+		if p.apiKey == "" {
+			logging.Warnf("Provider %s has no API key, downloads from it will fail", p.name)
+		}
+		return p, nil
+
+	case "dbip":
+		return &dbipProvider{
+			name:           firstNonEmpty(pc.Name, "DB-IP"),
+			urlTemplate:    firstNonEmpty(pc.URL, settings.dbipURL),
+			expectedSHA256: firstNonEmpty(pc.ExpectedSHA256, settings.dbipSHA256),
+			checksumURL:    firstNonEmpty(pc.ChecksumURL, settings.dbipChecksumURL),
+		}, nil
+
+	case "url":
+		return newURLProvider(pc)
+
+	case "file":
+		if pc.Path == "" {
+			return nil, fmt.Errorf("path is required")
+		}
+		p := &fileProvider{
+			name:           firstNonEmpty(pc.Name, "file"),
+			path:           pc.Path,
+			expectedSHA256: pc.ExpectedSHA256,
+		}
+		if pc.Watch {
+			return &watchedFileProvider{p}, nil
+		}
+		return p, nil
+
+	default:
+		return nil, fmt.Errorf("unknown provider type: %s", pc.Type)
+	}
+}
+
+// installDownload verifies d against expectedSHA256 or the digest published
+// at checksumURL (if either is set), extracts the database and remembers the
+// download once the database is installed
+func installDownload(dl *Downloader, source string, d *Download, expectedSHA256, checksumURL, match string, install func(io.Reader) error) error {
+	if expectedSHA256 == "" && checksumURL != "" {
+		var err error
+		if expectedSHA256, err = dl.Checksum(source, checksumURL); err != nil {
+			return fmt.Errorf("failed to get %s checksum: %w", source, err)
+		}
+	}
+	if expectedSHA256 != "" {
+		if err := verifyChecksum(source, expectedSHA256, d.SHA256); err != nil {
+			return err
+		}
+	}
+
+	if err := extractDatabase(d.Path, match, install); err != nil {
+		return fmt.Errorf("failed to extract %s database: %w", source, err)
+	}
+
+	if err := dl.Commit(d); err != nil {
+		log.Printf("Failed to save download state: %v", err)
+	}
+
+	return nil
+}
+
+// extractDatabase passes the database contained in the file at path to
+// install. The format is detected from the content: gzip compressed files are
+// decompressed and tar archives are searched for the first .mmdb file whose
+// name contains match. Anything else is installed as is.
+func extractDatabase(path, match string, install func(io.Reader) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	if magic, err := reader.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gzr, err := gzip.NewReader(reader)
+		if err != nil {
+			return err
+		}
+		defer gzr.Close()
+
+		reader = bufio.NewReader(gzr)
+	}
+
+	// Tar archives carry the "ustar" magic in the first header block
+	if header, err := reader.Peek(262); err == nil && string(header[257:262]) == "ustar" {
+		return extractFromTar(tar.NewReader(reader), match, install)
+	}
+
+	return install(reader)
+}
+
+func extractFromTar(tr *tar.Reader, match string, install func(io.Reader) error) error {
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		// Look for the .mmdb file
+		if strings.HasSuffix(header.Name, ".mmdb") && strings.Contains(header.Name, match) {
+			return install(tr)
+		}
+	}
+
+	if match != "" {
+		return fmt.Errorf("%s.mmdb not found in archive", match)
+	}
+	return fmt.Errorf("no .mmdb file found in archive")
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
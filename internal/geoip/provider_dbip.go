@@ -0,0 +1,57 @@
+/*
+ * Copyright (C) 2025  GeorgH93
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package geoip
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// dbipProvider downloads the free monthly DB-IP lite databases
+type dbipProvider struct {
+	name           string
+	urlTemplate    string // {YYYY-MM} is replaced with the current month
+	expectedSHA256 string
+	checksumURL    string // {YYYY-MM} is replaced with the current month
+}
+
+func (p *dbipProvider) Name() string {
+	return p.name
+}
+
+func (p *dbipProvider) Fetch(dl *Downloader, install func(io.Reader) error) error {
+	// Format current date for DB-IP URL (YYYY-MM format)
+	currentDate := time.Now().Format("2006-01")
+	downloadURL := strings.Replace(p.urlTemplate, "{YYYY-MM}", currentDate, 1)
+	checksumURL := strings.Replace(p.checksumURL, "{YYYY-MM}", currentDate, 1)
+
+	// Download the .mmdb.gz file
+	d, err := dl.Download(p.name, downloadURL, nil)
+	if errors.Is(err, ErrNotModified) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("failed to download from DB-IP: %w", err)
+	}
+	defer d.Discard()
+
+	return installDownload(dl, p.name, d, p.expectedSHA256, checksumURL, "", install)
+}
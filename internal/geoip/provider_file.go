@@ -0,0 +1,217 @@
+/*
+ * Copyright (C) 2025  GeorgH93
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package geoip
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"micro_geoip/internal/logging"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchDebounce is how long a watched path has to be quiet before it is
+// imported, so partially copied files and ConfigMap symlink swaps settle first
+var watchDebounce = 2 * time.Second
+
+// databaseFileSuffixes are the file names picked up from an import directory
+var databaseFileSuffixes = []string{".mmdb", ".mmdb.gz", ".tar.gz", ".tgz"}
+
+// fileProvider imports a database from a local path, e.g. a mounted volume.
+// The path may be a file or a directory, in which case the most recently
+// modified .mmdb, .mmdb.gz or .tar.gz file in it is used.
+type fileProvider struct {
+	name           string
+	path           string
+	expectedSHA256 string
+}
+
+func (p *fileProvider) Name() string {
+	return p.name
+}
+
+func (p *fileProvider) Fetch(dl *Downloader, install func(io.Reader) error) error {
+	path, err := p.resolve()
+	if err != nil {
+		return err
+	}
+
+	d, err := dl.Local(p.name, path)
+	if errors.Is(err, ErrNotModified) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	return installDownload(dl, p.name, d, p.expectedSHA256, "", "", install)
+}
+
+// resolve returns the file to import from the configured path
+func (p *fileProvider) resolve() (string, error) {
+	info, err := os.Stat(p.path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", p.path, err)
+	}
+	if !info.IsDir() {
+		return p.path, nil
+	}
+
+	entries, err := os.ReadDir(p.path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read directory %s: %w", p.path, err)
+	}
+
+	var newest string
+	var newestTime time.Time
+	for _, entry := range entries {
+		if !isDatabaseFile(entry.Name()) {
+			continue
+		}
+
+		// Stat follows symlinks, as used by Kubernetes ConfigMap and Secret volumes
+		path := filepath.Join(p.path, entry.Name())
+		info, err := os.Stat(path)
+		if err != nil || info.IsDir() {
+			continue
+		}
+		if newest == "" || info.ModTime().After(newestTime) {
+			newest, newestTime = path, info.ModTime()
+		}
+	}
+
+	if newest == "" {
+		return "", fmt.Errorf("no database file found in %s", p.path)
+	}
+	return newest, nil
+}
+
+func isDatabaseFile(name string) bool {
+	// Hidden files are temporary copies or ConfigMap internals
+	if strings.HasPrefix(name, ".") {
+		return false
+	}
+
+	for _, suffix := range databaseFileSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// watchedFileProvider is a fileProvider that also watches its path and
+// imports new databases as soon as they appear
+type watchedFileProvider struct {
+	*fileProvider
+}
+
+// Watch calls notify whenever the watched path changes, until the returned
+// closer is closed. Bursts of changes are reported once.
+func (p *watchedFileProvider) Watch(notify func()) (io.Closer, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	// Watch the parent of a single file, files are usually replaced rather than written in place
+	dir := p.path
+	if info, err := os.Stat(p.path); err != nil || !info.IsDir() {
+		dir = filepath.Dir(p.path)
+	}
+
+	if err := watcher.Add(dir); err != nil {
+		watcher.Close()
+		return nil, fmt.Errorf("failed to watch %s: %w", dir, err)
+	}
+
+	w := &fileWatcher{watcher: watcher}
+	go func() {
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if event.Op == fsnotify.Chmod {
+					continue
+				}
+				w.debounce(notify)
+
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Printf("Error watching %s: %v", dir, err)
+			}
+		}
+	}()
+
+	logging.Infof("Watching %s for new GeoIP databases", dir)
+	return w, nil
+}
+
+// fileWatcher is a started watch, closing it also cancels a pending notification
+type fileWatcher struct {
+	watcher *fsnotify.Watcher
+
+	mu     sync.Mutex
+	timer  *time.Timer
+	closed bool
+}
+
+// debounce calls notify once no further change arrived for watchDebounce
+func (w *fileWatcher) debounce(notify func()) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return
+	}
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	w.timer = time.AfterFunc(watchDebounce, func() {
+		w.mu.Lock()
+		closed := w.closed
+		w.mu.Unlock()
+
+		if !closed {
+			notify()
+		}
+	})
+}
+
+func (w *fileWatcher) Close() error {
+	w.mu.Lock()
+	w.closed = true
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	w.mu.Unlock()
+
+	return w.watcher.Close()
+}
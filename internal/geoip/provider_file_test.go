@@ -0,0 +1,174 @@
+/*
+ * Copyright (C) 2025  GeorgH93
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package geoip
+
+import (
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"micro_geoip/internal/config"
+)
+
+func TestFileProviderResolvesNewestInDirectory(t *testing.T) {
+	dir := t.TempDir()
+	old := filepath.Join(dir, "old.mmdb")
+	newest := filepath.Join(dir, "newest.mmdb.gz")
+
+	for _, name := range []string{old, newest, filepath.Join(dir, "notes.txt"), filepath.Join(dir, ".hidden.mmdb")} {
+		if err := os.WriteFile(name, []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	now := time.Now()
+	os.Chtimes(old, now.Add(-time.Hour), now.Add(-time.Hour))
+	os.Chtimes(newest, now, now)
+	os.Chtimes(filepath.Join(dir, "notes.txt"), now.Add(time.Hour), now.Add(time.Hour))
+	os.Chtimes(filepath.Join(dir, ".hidden.mmdb"), now.Add(time.Hour), now.Add(time.Hour))
+
+	p := &fileProvider{name: "file", path: dir}
+	path, err := p.resolve()
+	if err != nil {
+		t.Fatalf("resolve failed: %v", err)
+	}
+	if path != newest {
+		t.Errorf("Expected %s, got %s", newest, path)
+	}
+
+	if _, err := (&fileProvider{name: "file", path: t.TempDir()}).resolve(); err == nil {
+		t.Error("Expected empty directory to fail")
+	}
+}
+
+// newWatchedService creates a service importing from a watched directory
+func newWatchedService(t *testing.T) (*Service, string) {
+	t.Helper()
+
+	previousDebounce := watchDebounce
+	watchDebounce = 50 * time.Millisecond
+	t.Cleanup(func() { watchDebounce = previousDebounce })
+
+	s := newTestService(t)
+	importDir := t.TempDir()
+
+	s.config.GeoIP.Providers = []config.ProviderConfig{{Type: "file", Path: importDir, Watch: true}}
+	var err error
+	if s.country.providers, err = newProviders(s.config, countrySettings(s.config)); err != nil {
+		t.Fatal(err)
+	}
+
+	s.startWatchers()
+	if len(s.watchers) != 1 {
+		t.Fatalf("Expected one watcher, got %d", len(s.watchers))
+	}
+
+	return s, importDir
+}
+
+func TestWatchedDirectoryHotReload(t *testing.T) {
+	s, importDir := newWatchedService(t)
+	before := s.country.reader.current.Load()
+
+	// Copy the database in like an operator would, under a temporary name first
+	tmpPath := filepath.Join(importDir, ".incoming")
+	if err := os.WriteFile(tmpPath, gzipFile(t, s.config.GeoIP.DatabasePath), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmpPath, filepath.Join(importDir, "GeoLite2-Country.mmdb.gz")); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for s.country.reader.current.Load() == before {
+		if time.Now().After(deadline) {
+			t.Fatal("Database was not reloaded after a new file appeared")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if _, err := s.GetCountry("8.8.8.8"); err != nil {
+		t.Errorf("Lookup after hot reload failed: %v", err)
+	}
+}
+
+func TestWatchCloseCancelsPendingNotification(t *testing.T) {
+	previousDebounce := watchDebounce
+	watchDebounce = 200 * time.Millisecond
+	t.Cleanup(func() { watchDebounce = previousDebounce })
+
+	dir := t.TempDir()
+	provider := &watchedFileProvider{&fileProvider{path: dir}}
+
+	var notifications atomic.Int32
+	closer, err := provider.Watch(func() { notifications.Add(1) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := closer.(*fileWatcher)
+
+	if err := os.WriteFile(filepath.Join(dir, "GeoLite2-Country.mmdb"), []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Close while the notification is pending
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		w.mu.Lock()
+		pending := w.timer != nil
+		w.mu.Unlock()
+		if pending {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Change was not picked up by the watcher")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	closer.Close()
+
+	time.Sleep(2 * watchDebounce)
+	if n := notifications.Load(); n != 0 {
+		t.Errorf("Expected no notification after Close, got %d", n)
+	}
+}
+
+func TestWatchedDirectoryRejectsInvalidFile(t *testing.T) {
+	s, importDir := newWatchedService(t)
+	before := s.country.reader.current.Load()
+
+	if err := os.WriteFile(filepath.Join(importDir, "broken.mmdb"), []byte("not a database"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Give the watcher time to pick up and reject the file
+	time.Sleep(500 * time.Millisecond)
+
+	// Taking the update lock waits for an import that is still running
+	s.updateMu.Lock()
+	s.updateMu.Unlock()
+
+	if s.country.reader.current.Load() != before {
+		t.Error("Invalid database must not replace the loaded one")
+	}
+	if _, err := s.GetCountry("8.8.8.8"); err != nil {
+		t.Errorf("Lookup after rejected import failed: %v", err)
+	}
+}
@@ -0,0 +1,62 @@
+/*
+ * Copyright (C) 2025  GeorgH93
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package geoip
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+)
+
+// maxMindProvider downloads GeoLite2/GeoIP2 editions from MaxMind. Every
+// download is verified against the SHA-256 digest MaxMind publishes for it.
+type maxMindProvider struct {
+	name    string
+	baseURL string
+	apiKey  string
+	edition string
+}
+
+func (p *maxMindProvider) Name() string {
+	return p.name
+}
+
+func (p *maxMindProvider) Fetch(dl *Downloader, install func(io.Reader) error) error {
+	if p.apiKey == "" {
+		return fmt.Errorf("no MaxMind API key provided")
+	}
+
+	// Build download URL
+	downloadURL := fmt.Sprintf("%s?edition_id=%s&license_key=%s&suffix=tar.gz",
+		p.baseURL,
+		url.QueryEscape(p.edition),
+		url.QueryEscape(p.apiKey))
+
+	// Download the tar.gz file
+	d, err := dl.Download(p.name, downloadURL, nil)
+	if errors.Is(err, ErrNotModified) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("failed to download from MaxMind: %w", err)
+	}
+	defer d.Discard()
+
+	return installDownload(dl, p.name, d, "", downloadURL+".sha256", p.edition, install)
+}
@@ -0,0 +1,250 @@
+/*
+ * Copyright (C) 2025  GeorgH93
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package geoip
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"micro_geoip/internal/config"
+	"micro_geoip/internal/metrics"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestExtractDatabaseFormats(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "GeoLite2-Country.mmdb")
+	writeTestDatabase(t, dbPath)
+
+	raw, err := os.ReadFile(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	formats := map[string][]byte{
+		"mmdb":    raw,
+		"mmdb.gz": gzipFile(t, dbPath),
+		"tar.gz":  maxMindArchive(t, dbPath),
+	}
+
+	for format, data := range formats {
+		path := filepath.Join(t.TempDir(), "database."+format)
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		var extracted []byte
+		err := extractDatabase(path, "GeoLite2-Country", func(r io.Reader) error {
+			extracted, err = io.ReadAll(r)
+			return err
+		})
+		if err != nil {
+			t.Errorf("Failed to extract %s: %v", format, err)
+			continue
+		}
+		if !bytes.Equal(extracted, raw) {
+			t.Errorf("Extracted %s does not match the database", format)
+		}
+	}
+}
+
+func TestExtractDatabaseMissingEdition(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "GeoLite2-Country.mmdb")
+	writeTestDatabase(t, dbPath)
+
+	path := filepath.Join(t.TempDir(), "database.tar.gz")
+	if err := os.WriteFile(path, maxMindArchive(t, dbPath), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	err := extractDatabase(path, "GeoLite2-City", func(r io.Reader) error { return nil })
+	if err == nil {
+		t.Error("Expected missing edition to fail")
+	}
+}
+
+func TestNewProviders(t *testing.T) {
+	testCases := []struct {
+		name       string
+		apiKey     string
+		preferDBIP bool
+		providers  []config.ProviderConfig
+		expected   []string
+	}{
+		{"no api key", "", false, nil, []string{"DB-IP"}},
+		{"api key", "key", false, nil, []string{"MaxMind", "DB-IP"}},
+		{"prefer dbip", "key", true, nil, []string{"DB-IP", "MaxMind"}},
+		{"explicit order", "key", false, []config.ProviderConfig{
+			{Type: "url", Name: "mirror", URL: "https://mirror.example.com/country.mmdb"},
+			{Type: "dbip"},
+			{Type: "file", Path: "/data/fallback.mmdb"},
+		}, []string{"mirror", "DB-IP", "file"}},
+		{"url name from host", "", false, []config.ProviderConfig{
+			{Type: "url", URL: "https://ipinfo.io/data/free/country.mmdb"},
+		}, []string{"ipinfo.io"}},
+	}
+
+	for _, tc := range testCases {
+		cfg := newTestConfig()
+		cfg.GeoIP.MaxMindAPIKey = tc.apiKey
+		cfg.GeoIP.PreferDBIP = tc.preferDBIP
+		cfg.GeoIP.Providers = tc.providers
+
+		providers, err := newProviders(cfg, countrySettings(cfg))
+		if err != nil {
+			t.Errorf("%s: newProviders failed: %v", tc.name, err)
+			continue
+		}
+
+		var names []string
+		for _, provider := range providers {
+			names = append(names, provider.Name())
+		}
+		if len(names) != len(tc.expected) {
+			t.Errorf("%s: expected providers %v, got %v", tc.name, tc.expected, names)
+			continue
+		}
+		for i := range names {
+			if names[i] != tc.expected[i] {
+				t.Errorf("%s: expected providers %v, got %v", tc.name, tc.expected, names)
+				break
+			}
+		}
+	}
+}
+
+func TestNewProvidersInvalid(t *testing.T) {
+	invalid := []config.ProviderConfig{
+		{Type: "ftp"},
+		{Type: "url"},
+		{Type: "file"},
+	}
+
+	for _, pc := range invalid {
+		cfg := newTestConfig()
+		cfg.GeoIP.Providers = []config.ProviderConfig{pc}
+		if _, err := newProviders(cfg, countrySettings(cfg)); err == nil {
+			t.Errorf("Expected provider %+v to be rejected", pc)
+		}
+	}
+}
+
+func TestProviderChainFallback(t *testing.T) {
+	s := newTestService(t)
+	data, err := os.ReadFile(s.config.GeoIP.DatabasePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+
+	var mirrorAuth string
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mirrorAuth = r.Header.Get("Authorization")
+		w.Write(data)
+	}))
+	defer mirror.Close()
+
+	s.config.GeoIP.Providers = []config.ProviderConfig{
+		{Type: "url", Name: "broken", URL: failing.URL + "/country.mmdb"},
+		{Type: "url", Name: "mirror", URL: mirror.URL + "/country.mmdb", Headers: map[string]string{"Authorization": "Bearer secret"}},
+	}
+	if s.country.providers, err = newProviders(s.config, countrySettings(s.config)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.country.download(); err != nil {
+		t.Fatalf("Expected fallback to mirror to succeed, got %v", err)
+	}
+	if mirrorAuth != "Bearer secret" {
+		t.Errorf("Expected configured Authorization header, got %q", mirrorAuth)
+	}
+
+	// Both providers were tried, only the mirror succeeded
+	if testutil.ToFloat64(metrics.UpdateAttempt.WithLabelValues("country", "broken")) == 0 {
+		t.Error("Expected an update attempt to be recorded for the broken provider")
+	}
+	if testutil.ToFloat64(metrics.UpdateSuccess.WithLabelValues("country", "broken")) != 0 {
+		t.Error("Expected no update success to be recorded for the broken provider")
+	}
+	if testutil.ToFloat64(metrics.UpdateSuccess.WithLabelValues("country", "mirror")) == 0 {
+		t.Error("Expected an update success to be recorded for the mirror")
+	}
+	if testutil.ToFloat64(metrics.DownloadBytes.WithLabelValues("mirror")) < float64(len(data)) {
+		t.Error("Expected the downloaded bytes to be recorded for the mirror")
+	}
+
+	// The mirror doesn't send validators, so a second run downloads again
+	if err := s.country.download(); err != nil {
+		t.Fatalf("Second download failed: %v", err)
+	}
+}
+
+func TestProviderChainAllFailing(t *testing.T) {
+	s := newTestService(t)
+
+	s.config.GeoIP.Providers = []config.ProviderConfig{
+		{Type: "file", Path: filepath.Join(t.TempDir(), "missing.mmdb")},
+		{Type: "maxmind"},
+	}
+	var err error
+	if s.country.providers, err = newProviders(s.config, countrySettings(s.config)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.country.download(); err == nil || errors.Is(err, ErrNotModified) {
+		t.Fatalf("Expected download to fail, got %v", err)
+	}
+}
+
+func TestFileProvider(t *testing.T) {
+	s := newTestService(t)
+
+	source := filepath.Join(t.TempDir(), "import.mmdb.gz")
+	if err := os.WriteFile(source, gzipFile(t, s.config.GeoIP.DatabasePath), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s.config.GeoIP.Providers = []config.ProviderConfig{{Type: "file", Path: source}}
+
+	var err error
+	if s.country.providers, err = newProviders(s.config, countrySettings(s.config)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.country.download(); err != nil {
+		t.Fatalf("Import from file failed: %v", err)
+	}
+
+	// The same unchanged file is not imported twice
+	if err := s.country.download(); !errors.Is(err, ErrNotModified) {
+		t.Errorf("Expected ErrNotModified for unchanged file, got %v", err)
+	}
+
+	if _, err := os.Stat(source); err != nil {
+		t.Errorf("Expected source file to be kept: %v", err)
+	}
+}
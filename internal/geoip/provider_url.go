@@ -0,0 +1,79 @@
+/*
+ * Copyright (C) 2025  GeorgH93
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package geoip
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"micro_geoip/internal/config"
+)
+
+// urlProvider downloads a database from a plain HTTP(S) URL, e.g. an internal
+// mirror or IPinfo's free mmdb. The file may be an .mmdb, .mmdb.gz or .tar.gz.
+type urlProvider struct {
+	name           string
+	url            string
+	header         http.Header
+	expectedSHA256 string
+	checksumURL    string
+}
+
+func newURLProvider(pc config.ProviderConfig) (*urlProvider, error) {
+	if pc.URL == "" {
+		return nil, fmt.Errorf("url is required")
+	}
+
+	u, err := url.Parse(pc.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid url: %w", err)
+	}
+
+	header := make(http.Header)
+	for name, value := range pc.Headers {
+		header.Set(name, value)
+	}
+
+	return &urlProvider{
+		name:           firstNonEmpty(pc.Name, u.Hostname()),
+		url:            pc.URL,
+		header:         header,
+		expectedSHA256: pc.ExpectedSHA256,
+		checksumURL:    pc.ChecksumURL,
+	}, nil
+}
+
+func (p *urlProvider) Name() string {
+	return p.name
+}
+
+func (p *urlProvider) Fetch(dl *Downloader, install func(io.Reader) error) error {
+	d, err := dl.Download(p.name, p.url, p.header)
+	if errors.Is(err, ErrNotModified) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("failed to download from %s: %w", p.name, err)
+	}
+	defer d.Discard()
+
+	return installDownload(dl, p.name, d, p.expectedSHA256, p.checksumURL, "", install)
+}
@@ -0,0 +1,108 @@
+/*
+ * Copyright (C) 2025  GeorgH93
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package geoip
+
+import (
+	"net/netip"
+	"sync"
+	"sync/atomic"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// readerHandle wraps a maxminddb.Reader with a reference count, so a reader that
+// has been replaced by an update is only closed after the last lookup using it
+// has finished. The slot holding the handle owns one reference.
+type readerHandle struct {
+	reader *maxminddb.Reader
+	refs   atomic.Int64
+
+	// Details about the file the reader was opened from
+	checksum string // Hex encoded SHA-256 digest of the file
+	source   string // Provider the file was taken from, if known
+
+	// Networks of each country, collected on first use
+	networksOnce sync.Once
+	networks     map[string][]netip.Prefix
+	networksErr  error
+}
+
+func newReaderHandle(reader *maxminddb.Reader) *readerHandle {
+	h := &readerHandle{reader: reader}
+	h.refs.Store(1)
+	return h
+}
+
+// tryAcquire takes a reference unless the handle has already been released
+// by its owner and all lookups, in which case the reader is (being) closed.
+func (h *readerHandle) tryAcquire() bool {
+	for {
+		refs := h.refs.Load()
+		if refs <= 0 {
+			return false
+		}
+		if h.refs.CompareAndSwap(refs, refs+1) {
+			return true
+		}
+	}
+}
+
+// release drops a reference and closes the reader once none are left.
+func (h *readerHandle) release() {
+	if h.refs.Add(-1) == 0 {
+		h.reader.Close()
+	}
+}
+
+// readerSlot holds the currently active reader and allows it to be swapped
+// without blocking concurrent lookups.
+type readerSlot struct {
+	current atomic.Pointer[readerHandle]
+	swaps   atomic.Uint64 // number of times the handle was swapped
+}
+
+// acquire returns the active reader with a reference taken, or nil if no
+// reader is loaded. Callers must release the returned handle.
+func (s *readerSlot) acquire() *readerHandle {
+	for {
+		h := s.current.Load()
+		if h == nil {
+			return nil
+		}
+		if h.tryAcquire() {
+			return h
+		}
+		// The handle was swapped out and fully released between Load and
+		// tryAcquire, so a newer one (or nil) is in place by now.
+	}
+}
+
+// swap installs h as the active handle and releases the slot's reference
+// to the previous one. Passing nil unloads the current reader.
+func (s *readerSlot) swap(h *readerHandle) {
+	old := s.current.Swap(h)
+	s.swaps.Add(1)
+	if old != nil {
+		old.release()
+	}
+}
+
+// loaded reports whether a reader is currently installed.
+func (s *readerSlot) loaded() bool {
+	return s.current.Load() != nil
+}
@@ -0,0 +1,307 @@
+/*
+ * Copyright (C) 2025  GeorgH93
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package geoip
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"micro_geoip/internal/config"
+	"micro_geoip/internal/metrics"
+
+	"github.com/maxmind/mmdbwriter"
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/oschwald/geoip2-golang"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// testCountries maps the networks written by writeTestDatabase to their countries
+var testCountries = map[string][2]string{
+	"8.8.8.0/24":       {"US", "United States"},
+	"1.1.1.0/24":       {"AU", "Australia"},
+	"134.195.196.0/22": {"DE", "Germany"},
+	"2001:4860::/32":   {"US", "United States"},
+}
+
+// writeTestDatabase writes a small GeoLite2-Country style database to path
+func writeTestDatabase(t testing.TB, path string) {
+	t.Helper()
+
+	records := make(map[string]mmdbtype.Map)
+	for cidr, country := range testCountries {
+		records[cidr] = mmdbtype.Map{
+			"country": mmdbtype.Map{
+				"iso_code": mmdbtype.String(country[0]),
+				"names":    mmdbtype.Map{"en": mmdbtype.String(country[1])},
+			},
+		}
+	}
+
+	writeDatabase(t, path, "GeoLite2-Country", records)
+}
+
+// writeDatabase writes a database of the given type with records keyed by network
+func writeDatabase(t testing.TB, path, databaseType string, records map[string]mmdbtype.Map) {
+	t.Helper()
+
+	writer, err := mmdbwriter.New(mmdbwriter.Options{
+		DatabaseType: databaseType,
+		BuildEpoch:   time.Now().Unix(),
+		Languages:    []string{"en"},
+		Description:  map[string]string{"en": "micro_geoip test database"},
+		RecordSize:   24,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create database writer: %v", err)
+	}
+
+	for cidr, record := range records {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatalf("Invalid test network %s: %v", cidr, err)
+		}
+		if err := writer.Insert(network, record); err != nil {
+			t.Fatalf("Failed to insert %s: %v", cidr, err)
+		}
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Failed to create database file: %v", err)
+	}
+	defer file.Close()
+
+	if _, err := writer.WriteTo(file); err != nil {
+		t.Fatalf("Failed to write database: %v", err)
+	}
+}
+
+// newTestConfig returns a configuration with the defaults relevant to the service
+func newTestConfig() *config.Config {
+	cfg := &config.Config{}
+	cfg.GeoIP.UpdateInterval = "720h"
+	return cfg
+}
+
+// newTestService creates a Service backed by a generated database without
+// touching the network or starting the update scheduler
+func newTestService(t testing.TB) *Service {
+	t.Helper()
+
+	cfg := newTestConfig()
+	cfg.GeoIP.DatabasePath = filepath.Join(t.TempDir(), "GeoLite2-Country.mmdb")
+	writeTestDatabase(t, cfg.GeoIP.DatabasePath)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	country, err := newDatabase(ctx, cfg, countryEdition, countrySettings(cfg))
+	if err != nil {
+		t.Fatalf("Failed to create country database: %v", err)
+	}
+
+	s := &Service{config: cfg, country: country, ctx: ctx, cancel: cancel}
+	if err := s.country.load(); err != nil {
+		t.Fatalf("Failed to load test database: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+
+	return s
+}
+
+// fetchFrom fetches the database with a provider of the given type, built
+// from the current service configuration
+func fetchFrom(t testing.TB, s *Service, providerType string) error {
+	t.Helper()
+
+	downloader, err := newDownloader(s.ctx, s.config, s.country.path)
+	if err != nil {
+		t.Fatalf("Failed to create downloader: %v", err)
+	}
+	s.country.downloader = downloader
+
+	provider, err := newProvider(s.config, countrySettings(s.config), config.ProviderConfig{Type: providerType})
+	if err != nil {
+		t.Fatalf("Failed to create %s provider: %v", providerType, err)
+	}
+
+	return provider.Fetch(downloader, s.country.install)
+}
+
+func TestGetCountryWithDatabase(t *testing.T) {
+	s := newTestService(t)
+
+	countryInfo, err := s.GetCountry("8.8.8.8")
+	if err != nil {
+		t.Fatalf("GetCountry failed: %v", err)
+	}
+
+	if countryInfo.Code != "US" || countryInfo.Name != "United States" {
+		t.Errorf("Expected US/United States, got %s/%s", countryInfo.Code, countryInfo.Name)
+	}
+
+	countryInfo, err = s.GetCountry("192.0.2.1")
+	if err != nil {
+		t.Fatalf("GetCountry failed: %v", err)
+	}
+
+	if countryInfo.Code != "Unknown" {
+		t.Errorf("Expected country code 'Unknown', got '%s'", countryInfo.Code)
+	}
+}
+
+func TestGetCountryNetwork(t *testing.T) {
+	s := newTestService(t)
+
+	tests := map[string]string{
+		"8.8.8.8":              "8.8.8.0/24",
+		"::ffff:8.8.8.8":       "8.8.8.0/24",
+		"134.195.199.1":        "134.195.196.0/22",
+		"2001:4860:4860::8888": "2001:4860::/32",
+	}
+	for ip, expected := range tests {
+		countryInfo, err := s.GetCountry(ip)
+		if err != nil {
+			t.Fatalf("GetCountry(%s) failed: %v", ip, err)
+		}
+		if countryInfo.Network.String() != expected {
+			t.Errorf("Expected network %s for %s, got %s", expected, ip, countryInfo.Network)
+		}
+	}
+
+	// Addresses without data belong to the surrounding network without records
+	countryInfo, err := s.GetCountry("192.0.2.1")
+	if err != nil {
+		t.Fatalf("GetCountry failed: %v", err)
+	}
+	if !countryInfo.Network.IsValid() || !countryInfo.Network.Contains(netip.MustParseAddr("192.0.2.1")) {
+		t.Errorf("Expected network containing 192.0.2.1, got %s", countryInfo.Network)
+	}
+}
+
+func TestLookupMetrics(t *testing.T) {
+	s := newTestService(t)
+
+	found := testutil.ToFloat64(metrics.Lookups.WithLabelValues("US"))
+	unknown := testutil.ToFloat64(metrics.Lookups.WithLabelValues("Unknown"))
+	failed := testutil.ToFloat64(metrics.LookupErrors)
+
+	s.GetCountry("8.8.8.8")
+	s.GetCountry("192.0.2.1")
+	s.GetCountry("invalid-ip")
+
+	if delta := testutil.ToFloat64(metrics.Lookups.WithLabelValues("US")) - found; delta != 1 {
+		t.Errorf("Expected 1 US lookup, got %v", delta)
+	}
+	if delta := testutil.ToFloat64(metrics.Lookups.WithLabelValues("Unknown")) - unknown; delta != 1 {
+		t.Errorf("Expected 1 unknown lookup, got %v", delta)
+	}
+	if delta := testutil.ToFloat64(metrics.LookupErrors) - failed; delta != 1 {
+		t.Errorf("Expected 1 failed lookup, got %v", delta)
+	}
+}
+
+func TestReaderReleasedAfterInFlightLookup(t *testing.T) {
+	s := newTestService(t)
+
+	// Hold a reference like an in-flight lookup would
+	handle := s.country.reader.acquire()
+	if handle == nil {
+		t.Fatal("Expected a loaded reader")
+	}
+
+	if err := s.country.load(); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+
+	if s.country.reader.current.Load() == handle {
+		t.Fatal("Expected reload to install a new reader")
+	}
+
+	// The old reader must still be usable until the lookup releases it
+	if err := handle.reader.Lookup(net.ParseIP("8.8.8.8"), &geoip2.Country{}); err != nil {
+		t.Errorf("Lookup on replaced reader failed before release: %v", err)
+	}
+
+	handle.release()
+
+	if refs := handle.refs.Load(); refs != 0 {
+		t.Errorf("Expected replaced reader to have no references left, got %d", refs)
+	}
+
+	if handle.tryAcquire() {
+		t.Error("Expected acquiring a fully released reader to fail")
+	}
+}
+
+func TestConcurrentLookupsDuringReload(t *testing.T) {
+	s := newTestService(t)
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+
+				countryInfo, err := s.GetCountry("8.8.8.8")
+				if err != nil {
+					t.Errorf("GetCountry failed during reload: %v", err)
+					return
+				}
+				if countryInfo.Code != "US" {
+					t.Errorf("Expected country code 'US', got '%s'", countryInfo.Code)
+					return
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < 50; i++ {
+		if err := s.country.load(); err != nil {
+			t.Errorf("Reload failed: %v", err)
+			break
+		}
+	}
+
+	close(stop)
+	wg.Wait()
+}
+
+func TestGetCountryAfterClose(t *testing.T) {
+	s := newTestService(t)
+
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	if _, err := s.GetCountry("8.8.8.8"); err == nil {
+		t.Error("Expected lookup to fail after Close")
+	}
+}
@@ -18,346 +18,628 @@
 package geoip
 
 import (
-	"archive/tar"
-	"compress/gzip"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"math/rand/v2"
 	"net"
-	"net/http"
-	"net/url"
+	"net/netip"
 	"os"
-	"strings"
+	"slices"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	"micro_geoip/internal/config"
-
 	"github.com/oschwald/geoip2-golang"
 	"github.com/robfig/cron/v3"
+	"micro_geoip/internal/config"
+	"micro_geoip/internal/logging"
+	"micro_geoip/internal/metrics"
 )
 
+// defaultUpdateInterval is used when the configured update interval is invalid
+const defaultUpdateInterval = 720 * time.Hour
+
 type Service struct {
-	config *config.Config
-	db     *geoip2.Reader
-	cron   *cron.Cron
-}
+	config   *config.Config
+	configMu sync.RWMutex // Guards config, which is replaced by Reconfigure
+	cron     *cron.Cron
 
-func NewService(cfg *config.Config) (*Service, error) {
-	s := &Service{
-		config: cfg,
-		cron:   cron.New(),
-	}
+	// ctx is cancelled by Close to abort running downloads
+	ctx    context.Context
+	cancel context.CancelFunc
 
-	// Ensure data directory exists
-	if err := os.MkdirAll(cfg.GetDatabaseDir(), 0755); err != nil {
-		return nil, fmt.Errorf("failed to create data directory: %w", err)
-	}
+	country  *database
+	city     *database // nil unless the city database is enabled
+	asn      *database // nil unless the ASN database is enabled
+	watchers []io.Closer
 
-	// Try to load existing database
-	if err := s.loadDatabase(); err != nil {
-		log.Printf("Failed to load existing database: %v", err)
+	// updateMu serializes updates, so scheduled and watch triggered updates don't overlap
+	updateMu sync.Mutex
 
-		// If no database exists, download it
-		log.Println("Downloading initial GeoIP database...")
-		if err := s.downloadDatabase(); err != nil {
-			return nil, fmt.Errorf("failed to download initial database: %w", err)
-		}
+	cronEntry cron.EntryID
+	jitter    atomic.Int64 // Maximum random delay of scheduled updates
+}
 
-		// Try to load the downloaded database
-		if err := s.loadDatabase(); err != nil {
-			return nil, fmt.Errorf("failed to load downloaded database: %w", err)
+// NewService creates the service used by the server. Missing databases are
+// downloaded in the background, and updates run on the configured schedule
+// and whenever a watched provider detects a new database.
+func NewService(cfg *config.Config) (*Service, error) {
+	s, err := newService(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// Load the existing databases. Missing ones are downloaded in the
+	// background so the server can start, lookups fail with
+	// ErrDatabaseUnavailable until then.
+	for _, d := range s.databases() {
+		if err := d.load(); err != nil {
+			log.Printf("Failed to load existing %s database: %v", d.edition.name, err)
+			go s.initialDownload(d)
 		}
 	}
 
 	// Set up automatic updates
+	s.cron = cron.New()
 	s.setupAutoUpdate()
+	s.startWatchers()
+
+	// Refresh an outdated database right away instead of waiting for the schedule
+	if s.databaseOutdated() {
+		logging.Infof("GeoIP database is outdated, updating in the background...")
+		go s.scheduledUpdate()
+	}
 
 	return s, nil
 }
 
-func (s *Service) loadDatabase() error {
-	if _, err := os.Stat(s.config.GeoIP.DatabasePath); os.IsNotExist(err) {
-		return fmt.Errorf("database file does not exist: %s", s.config.GeoIP.DatabasePath)
-	}
-
-	db, err := geoip2.Open(s.config.GeoIP.DatabasePath)
+// OpenService creates a service for one-off commands. The existing databases
+// are loaded, but nothing is downloaded or updated unless Update is called.
+func OpenService(cfg *config.Config) (*Service, error) {
+	s, err := newService(cfg)
 	if err != nil {
-		return fmt.Errorf("failed to open GeoIP database: %w", err)
+		return nil, err
 	}
 
-	// Close old database if exists
-	if s.db != nil {
-		s.db.Close()
+	for _, d := range s.databases() {
+		if err := d.load(); err != nil {
+			logging.Infof("GeoIP %s database not loaded: %v", d.edition.name, err)
+		}
 	}
 
-	s.db = db
-	log.Printf("GeoIP database loaded: %s", s.config.GeoIP.DatabasePath)
-	return nil
+	return s, nil
 }
 
-func (s *Service) downloadDatabase() error {
-	// Try MaxMind first if API key is available and not preferring DB-IP
-	if s.config.GeoIP.MaxMindAPIKey != "" && !s.config.GeoIP.PreferDBIP {
-		if err := s.downloadMaxMindDatabase(); err != nil {
-			log.Printf("MaxMind download failed: %v, trying DB-IP fallback", err)
-			return s.downloadDBIPDatabase()
-		}
-		return nil
+// newService sets up the configured databases without loading them
+func newService(cfg *config.Config) (*Service, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Service{
+		config: cfg,
+		ctx:    ctx,
+		cancel: cancel,
 	}
 
-	// Try DB-IP first (free database)
-	if err := s.downloadDBIPDatabase(); err != nil {
-		log.Printf("DB-IP download failed: %v", err)
+	var err error
+	if s.country, err = newDatabase(ctx, cfg, countryEdition, countrySettings(cfg)); err != nil {
+		cancel()
+		return nil, err
+	}
 
-		// Fallback to MaxMind if API key is available
-		if s.config.GeoIP.MaxMindAPIKey != "" {
-			log.Printf("Trying MaxMind fallback...")
-			return s.downloadMaxMindDatabase()
+	if cfg.GeoIP.City.Enabled {
+		if s.city, err = newDatabase(ctx, cfg, cityEdition, citySettings(cfg)); err != nil {
+			cancel()
+			return nil, err
 		}
+	}
 
-		return fmt.Errorf("no database source available: %w", err)
+	if cfg.GeoIP.ASN.Enabled {
+		if s.asn, err = newDatabase(ctx, cfg, asnEdition, asnSettings(cfg)); err != nil {
+			cancel()
+			return nil, err
+		}
 	}
 
-	return nil
+	// Ensure data directory exists
+	if err := os.MkdirAll(cfg.GetDatabaseDir(), 0755); err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to create data directory: %w", err)
+	}
+
+	return s, nil
 }
 
-func (s *Service) downloadMaxMindDatabase() error {
-	if s.config.GeoIP.MaxMindAPIKey == "" {
-		return fmt.Errorf("no MaxMind API key provided")
+// initialDownload downloads a database that couldn't be loaded at startup,
+// retrying with exponential backoff until it succeeds or the service is closed
+func (s *Service) initialDownload(d *database) {
+	backoff, err := time.ParseDuration(s.currentConfig().GeoIP.Download.RetryBackoff)
+	if err != nil || backoff <= 0 {
+		backoff = time.Second
 	}
 
-	// Build download URL
-	downloadURL := fmt.Sprintf("%s?edition_id=GeoLite2-Country&license_key=%s&suffix=tar.gz",
-		s.config.GeoIP.MaxMindURL,
-		url.QueryEscape(s.config.GeoIP.MaxMindAPIKey))
+	for !s.tryInitialDownload(d) {
+		logging.Infof("Retrying initial %s database download in %s", d.edition.name, backoff)
+		if !sleepContext(s.ctx, backoff) {
+			return
+		}
+		backoff = min(backoff*2, maxRetryBackoff)
+	}
+}
 
-	log.Printf("Downloading GeoIP database from MaxMind...")
+// tryInitialDownload makes one attempt to download and load a missing
+// database. It returns true once no further attempt is needed.
+func (s *Service) tryInitialDownload(d *database) bool {
+	s.updateMu.Lock()
+	defer s.updateMu.Unlock()
 
-	// Download the tar.gz file
-	resp, err := http.Get(downloadURL)
-	if err != nil {
-		return fmt.Errorf("failed to download from MaxMind: %w", err)
+	// The service was closed or an update loaded the database meanwhile
+	if s.ctx.Err() != nil || d.reader.loaded() {
+		return true
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("MaxMind download failed with status: %d", resp.StatusCode)
+	logging.Infof("Downloading initial GeoIP %s database...", d.edition.name)
+	if err := d.download(); err != nil && !errors.Is(err, ErrNotModified) {
+		logDownloadError(fmt.Sprintf("Failed to download initial %s database", d.edition.name), err)
+		return false
 	}
 
-	// Create temporary file
-	tmpFile, err := os.CreateTemp("", "maxmind-geoip-*.tar.gz")
-	if err != nil {
-		return fmt.Errorf("failed to create temporary file: %w", err)
+	if err := d.load(); err != nil {
+		log.Printf("Failed to load downloaded %s database: %v", d.edition.name, err)
+		return false
+	}
+	return true
+}
+
+// databases returns all databases managed by the service
+func (s *Service) databases() []*database {
+	databases := []*database{s.country}
+	if s.city != nil {
+		databases = append(databases, s.city)
+	}
+	if s.asn != nil {
+		databases = append(databases, s.asn)
 	}
-	defer os.Remove(tmpFile.Name())
-	defer tmpFile.Close()
+	return databases
+}
 
-	// Save downloaded content
-	if _, err := io.Copy(tmpFile, resp.Body); err != nil {
-		return fmt.Errorf("failed to save downloaded file: %w", err)
+// Generation changes whenever a database is loaded, replaced or unloaded, so
+// results derived from lookups can be invalidated
+func (s *Service) Generation() uint64 {
+	generation := s.country.reader.swaps.Load()
+	if s.city != nil {
+		generation += s.city.reader.swaps.Load()
+	}
+	if s.asn != nil {
+		generation += s.asn.reader.swaps.Load()
 	}
+	return generation
+}
 
-	// Extract the database file
-	if err := s.extractMaxMindDatabase(tmpFile.Name()); err != nil {
-		return fmt.Errorf("failed to extract MaxMind database: %w", err)
+// logDownloadError logs a failed download, calling out checksum mismatches
+// since they indicate a corrupted or tampered download rather than an outage
+func logDownloadError(msg string, err error) {
+	var checksumErr *ChecksumError
+	if errors.As(err, &checksumErr) {
+		log.Printf("%s: checksum verification failed for %s download (expected %s, got %s)",
+			msg, checksumErr.Source, checksumErr.Expected, checksumErr.Actual)
+		return
 	}
 
-	log.Printf("MaxMind GeoIP database downloaded and extracted successfully")
-	return nil
+	log.Printf("%s: %v", msg, err)
 }
 
-func (s *Service) downloadDBIPDatabase() error {
-	// Format current date for DB-IP URL (YYYY-MM format)
-	currentDate := time.Now().Format("2006-01")
-	downloadURL := strings.Replace(s.config.GeoIP.DBIPUrl, "{YYYY-MM}", currentDate, 1)
-
-	log.Printf("Downloading GeoIP database from DB-IP...")
+func (s *Service) setupAutoUpdate() {
+	cfg := s.currentConfig()
+	s.jitter.Store(int64(parseOptionalDuration("update jitter", cfg.GeoIP.UpdateJitter)))
+	s.schedule(updateSchedule(cfg))
+	s.cron.Start()
+}
 
-	// Download the .mmdb.gz file
-	resp, err := http.Get(downloadURL)
+// schedule replaces the schedule of automatic updates
+func (s *Service) schedule(cronSpec string) {
+	entry, err := s.cron.AddFunc(cronSpec, s.scheduledUpdate)
 	if err != nil {
-		return fmt.Errorf("failed to download from DB-IP: %w", err)
+		log.Printf("Failed to schedule database updates: %v", err)
+		return
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("DB-IP download failed with status: %d", resp.StatusCode)
+	if s.cronEntry != 0 {
+		s.cron.Remove(s.cronEntry)
 	}
+	s.cronEntry = entry
+	logging.Infof("Scheduled automatic database updates: %s", cronSpec)
+}
 
-	// Create temporary file
-	tmpFile, err := os.CreateTemp("", "dbip-geoip-*.mmdb.gz")
-	if err != nil {
-		return fmt.Errorf("failed to create temporary file: %w", err)
+// updateSchedule returns the cron spec for automatic updates. An explicit
+// update schedule takes precedence over the update interval.
+func updateSchedule(cfg *config.Config) string {
+	if schedule := cfg.GeoIP.UpdateSchedule; schedule != "" {
+		_, err := cron.ParseStandard(schedule)
+		if err == nil {
+			return schedule
+		}
+		logging.Warnf("Invalid update schedule '%s', using update interval: %v", schedule, err)
 	}
-	defer os.Remove(tmpFile.Name())
-	defer tmpFile.Close()
 
-	// Save downloaded content
-	if _, err := io.Copy(tmpFile, resp.Body); err != nil {
-		return fmt.Errorf("failed to save downloaded file: %w", err)
+	interval, err := time.ParseDuration(cfg.GeoIP.UpdateInterval)
+	if err != nil || interval <= 0 {
+		logging.Warnf("Invalid update interval '%s', using default (30 days): %v", cfg.GeoIP.UpdateInterval, err)
+		interval = defaultUpdateInterval
 	}
 
-	// Extract the database file
-	if err := s.extractDBIPDatabase(tmpFile.Name()); err != nil {
-		return fmt.Errorf("failed to extract DB-IP database: %w", err)
+	return "@every " + interval.String()
+}
+
+// scheduledUpdate waits for a random jitter, so that a fleet of instances
+// doesn't hit the provider at the same time, and then updates the database.
+func (s *Service) scheduledUpdate() {
+	if jitter := time.Duration(s.jitter.Load()); jitter > 0 {
+		delay := rand.N(jitter)
+		logging.Infof("Delaying database update by %s", delay.Round(time.Second))
+		if !sleepContext(s.ctx, delay) {
+			return
+		}
 	}
 
-	log.Printf("DB-IP GeoIP database downloaded and extracted successfully")
-	return nil
+	s.Update()
 }
 
-func (s *Service) extractMaxMindDatabase(tarGzPath string) error {
-	file, err := os.Open(tarGzPath)
-	if err != nil {
+// Update downloads new releases of all databases from their provider chains
+// and loads them. Updates are serialized, an update started while another
+// one is running waits for it to finish.
+func (s *Service) Update() error {
+	s.updateMu.Lock()
+	defer s.updateMu.Unlock()
+
+	if err := s.ctx.Err(); err != nil {
 		return err
 	}
-	defer file.Close()
 
-	gzr, err := gzip.NewReader(file)
-	if err != nil {
-		return err
+	logging.Infof("Starting GeoIP database update...")
+	var errs []error
+	for _, d := range s.databases() {
+		if err := d.update(); err != nil {
+			errs = append(errs, err)
+		}
 	}
-	defer gzr.Close()
+	logging.Infof("GeoIP database update finished")
 
-	tr := tar.NewReader(gzr)
+	return errors.Join(errs...)
+}
 
-	for {
-		header, err := tr.Next()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return err
+// Reload re-opens all databases from disk, e.g. after they have been
+// replaced by an external tool
+func (s *Service) Reload() error {
+	s.updateMu.Lock()
+	defer s.updateMu.Unlock()
+
+	if err := s.ctx.Err(); err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, d := range s.databases() {
+		if err := d.load(); err != nil {
+			log.Printf("Failed to reload %s database: %v", d.edition.name, err)
+			errs = append(errs, fmt.Errorf("failed to reload %s database: %w", d.edition.name, err))
 		}
+	}
 
-		// Look for the .mmdb file
-		if strings.HasSuffix(header.Name, ".mmdb") && strings.Contains(header.Name, "GeoLite2-Country") {
-			// Extract to the configured path
-			outFile, err := os.Create(s.config.GeoIP.DatabasePath)
-			if err != nil {
-				return err
-			}
-			defer outFile.Close()
+	return errors.Join(errs...)
+}
 
-			if _, err := io.Copy(outFile, tr); err != nil {
-				return err
+// startWatchers starts watching all providers that can detect new databases
+// themselves, importing from them as soon as a change is detected
+func (s *Service) startWatchers() {
+	for _, d := range s.databases() {
+		for _, provider := range d.providers {
+			watchable, ok := provider.(watchableProvider)
+			if !ok {
+				continue
 			}
 
-			return nil
+			watcher, err := watchable.Watch(func() { s.updateFrom(d, watchable) })
+			if err != nil {
+				log.Printf("Failed to watch %s: %v", provider.Name(), err)
+				continue
+			}
+			s.watchers = append(s.watchers, watcher)
 		}
 	}
+}
 
-	return fmt.Errorf("GeoLite2-Country.mmdb not found in MaxMind archive")
+// stopWatchers stops all watchers started by startWatchers
+func (s *Service) stopWatchers() {
+	for _, watcher := range s.watchers {
+		watcher.Close()
+	}
+	s.watchers = nil
 }
 
-func (s *Service) extractDBIPDatabase(gzPath string) error {
-	file, err := os.Open(gzPath)
-	if err != nil {
-		return err
+// Reconfigure applies the settings of cfg that can change at runtime: the
+// update schedule and jitter, the download settings and the provider chains
+// of all databases. cfg replaces the configuration of the service. Nothing
+// is changed if the provider configuration is invalid.
+func (s *Service) Reconfigure(cfg *config.Config) error {
+	databases := s.databases()
+	providers := make([][]Provider, len(databases))
+	for i, d := range databases {
+		var err error
+		if providers[i], err = newProviders(cfg, d.edition.settings(cfg)); err != nil {
+			return fmt.Errorf("failed to set up %s database providers: %w", d.edition.name, err)
+		}
 	}
-	defer file.Close()
 
-	gzr, err := gzip.NewReader(file)
-	if err != nil {
+	s.updateMu.Lock()
+	defer s.updateMu.Unlock()
+
+	if err := s.ctx.Err(); err != nil {
 		return err
 	}
-	defer gzr.Close()
 
-	// Create output file
-	outFile, err := os.Create(s.config.GeoIP.DatabasePath)
-	if err != nil {
-		return err
+	s.configMu.Lock()
+	s.config = cfg
+	s.configMu.Unlock()
+
+	// Downloads only run while holding updateMu, so they see either config
+	for i, d := range databases {
+		d.providers = providers[i]
+		d.downloader.config = cfg
 	}
-	defer outFile.Close()
+	s.stopWatchers()
+	s.startWatchers()
 
-	// Copy decompressed content
-	if _, err := io.Copy(outFile, gzr); err != nil {
-		return err
+	s.jitter.Store(int64(parseOptionalDuration("update jitter", cfg.GeoIP.UpdateJitter)))
+	if s.cron != nil {
+		s.schedule(updateSchedule(cfg))
 	}
 
 	return nil
 }
 
-func (s *Service) setupAutoUpdate() {
-	// Parse update interval
-	_, err := time.ParseDuration(s.config.GeoIP.UpdateInterval)
-	if err != nil {
-		log.Printf("Invalid update interval '%s', using default (30 days): %v", s.config.GeoIP.UpdateInterval, err)
+// currentConfig returns the configuration last applied by Reconfigure
+func (s *Service) currentConfig() *config.Config {
+	s.configMu.RLock()
+	defer s.configMu.RUnlock()
+
+	return s.config
+}
+
+// updateFrom imports a database from a single provider and reloads it
+func (s *Service) updateFrom(d *database, provider Provider) {
+	s.updateMu.Lock()
+	defer s.updateMu.Unlock()
+
+	// The provider may have been replaced by Reconfigure meanwhile
+	if s.ctx.Err() != nil || !slices.Contains(d.providers, provider) {
+		return
 	}
 
-	// Convert to cron format (approximate - runs once per month)
-	cronSpec := "0 0 1 * *" // First day of every month at midnight
+	d.importFrom(provider)
+}
 
-	_, err = s.cron.AddFunc(cronSpec, func() {
-		log.Println("Starting scheduled GeoIP database update...")
-		if err := s.downloadDatabase(); err != nil {
-			log.Printf("Scheduled database update failed: %v", err)
-			return
-		}
+// databaseOutdated reports whether any loaded database was built longer ago
+// than the configured update-if-older-than age.
+func (s *Service) databaseOutdated() bool {
+	maxAge := parseOptionalDuration("update-if-older-than age", s.currentConfig().GeoIP.UpdateIfOlderThan)
+	if maxAge <= 0 {
+		return false
+	}
 
-		if err := s.loadDatabase(); err != nil {
-			log.Printf("Failed to reload database after update: %v", err)
-			return
+	for _, d := range s.databases() {
+		if buildTime, ok := d.buildTime(); ok && time.Since(buildTime) > maxAge {
+			return true
 		}
+	}
 
-		log.Println("Scheduled GeoIP database update completed successfully")
-	})
+	return false
+}
+
+// parseOptionalDuration parses an optional duration setting, an empty or
+// invalid value disables the setting.
+func parseOptionalDuration(name, value string) time.Duration {
+	if value == "" {
+		return 0
+	}
 
+	duration, err := time.ParseDuration(value)
 	if err != nil {
-		log.Printf("Failed to schedule database updates: %v", err)
-		return
+		logging.Warnf("Invalid %s '%s', ignoring it: %v", name, value, err)
+		return 0
 	}
 
-	s.cron.Start()
-	log.Printf("Scheduled automatic database updates: %s", cronSpec)
+	return duration
 }
 
 func (s *Service) GetCountry(ip string) (*CountryInfo, error) {
-	if s.db == nil {
-		return nil, fmt.Errorf("GeoIP database not available")
+	countryInfo, err := s.lookupCountry(ip)
+	if err != nil {
+		metrics.LookupErrors.Inc()
+		logging.Debugf("Country lookup for %s failed: %v", ip, err)
+		return nil, err
 	}
 
+	metrics.Lookups.WithLabelValues(countryInfo.Code).Inc()
+	return countryInfo, nil
+}
+
+func (s *Service) lookupCountry(ip string) (*CountryInfo, error) {
 	parsedIP := net.ParseIP(ip)
 	if parsedIP == nil {
 		return nil, fmt.Errorf("invalid IP address: %s", ip)
 	}
 
-	record, err := s.db.Country(parsedIP)
+	db, err := s.country.acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer db.release()
+
+	var record geoip2.Country
+	network, _, err := db.reader.LookupNetwork(parsedIP, &record)
 	if err != nil {
 		return nil, fmt.Errorf("GeoIP lookup failed: %w", err)
 	}
 
+	countryInfo := countryInfo(record.Country.IsoCode, record.Country.Names)
+	countryInfo.Network = networkPrefix(network)
+	return countryInfo, nil
+}
+
+// GetLocation looks up city level information, it requires the city database
+func (s *Service) GetLocation(ip string) (*LocationInfo, error) {
+	parsedIP := net.ParseIP(ip)
+	if parsedIP == nil {
+		return nil, fmt.Errorf("invalid IP address: %s", ip)
+	}
+
+	if s.city == nil {
+		return nil, fmt.Errorf("GeoIP city %w", ErrDatabaseUnavailable)
+	}
+
+	db, err := s.city.acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer db.release()
+
+	var record geoip2.City
+	network, _, err := db.reader.LookupNetwork(parsedIP, &record)
+	if err != nil {
+		return nil, fmt.Errorf("GeoIP lookup failed: %w", err)
+	}
+
+	location := &LocationInfo{
+		CountryInfo:    *countryInfo(record.Country.IsoCode, record.Country.Names),
+		City:           localizedName(record.City.Names),
+		PostalCode:     record.Postal.Code,
+		Latitude:       record.Location.Latitude,
+		Longitude:      record.Location.Longitude,
+		AccuracyRadius: record.Location.AccuracyRadius,
+		TimeZone:       record.Location.TimeZone,
+	}
+	location.Network = networkPrefix(network)
+
+	// The first subdivision is the largest one, e.g. the state
+	if len(record.Subdivisions) > 0 {
+		subdivision := record.Subdivisions[0]
+		location.Subdivision = localizedName(subdivision.Names)
+		if subdivision.IsoCode != "" && record.Country.IsoCode != "" {
+			location.SubdivisionCode = record.Country.IsoCode + "-" + subdivision.IsoCode
+		}
+	}
+
+	return location, nil
+}
+
+// GetASN looks up the autonomous system, it requires the ASN database
+func (s *Service) GetASN(ip string) (*ASNInfo, error) {
+	parsedIP := net.ParseIP(ip)
+	if parsedIP == nil {
+		return nil, fmt.Errorf("invalid IP address: %s", ip)
+	}
+
+	if s.asn == nil {
+		return nil, fmt.Errorf("GeoIP ASN %w", ErrDatabaseUnavailable)
+	}
+
+	db, err := s.asn.acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer db.release()
+
+	var record geoip2.ASN
+	network, _, err := db.reader.LookupNetwork(parsedIP, &record)
+	if err != nil {
+		return nil, fmt.Errorf("ASN lookup failed: %w", err)
+	}
+
+	return &ASNInfo{
+		Number:       record.AutonomousSystemNumber,
+		Organization: record.AutonomousSystemOrganization,
+		Network:      networkPrefix(network),
+	}, nil
+}
+
+// Status returns the state of all configured databases, the country database first
+func (s *Service) Status() []DatabaseStatus {
+	var statuses []DatabaseStatus
+	for _, d := range s.databases() {
+		statuses = append(statuses, d.status())
+	}
+	return statuses
+}
+
+// countryInfo builds the country information from a lookup record
+func countryInfo(isoCode string, names map[string]string) *CountryInfo {
 	countryInfo := &CountryInfo{
 		Code: "Unknown",
 		Name: "Unknown",
 	}
 
 	// Set country code
-	if record.Country.IsoCode != "" {
-		countryInfo.Code = record.Country.IsoCode
-	}
-
-	// Set country name (prefer English)
-	if record.Country.Names["en"] != "" {
-		countryInfo.Name = record.Country.Names["en"]
-	} else if len(record.Country.Names) > 0 {
-		// Fallback to any available name
-		for _, name := range record.Country.Names {
-			countryInfo.Name = name
-			break
-		}
+	if isoCode != "" {
+		countryInfo.Code = isoCode
 	}
 
-	return countryInfo, nil
+	// Set country name
+	if name := localizedName(names); name != "" {
+		countryInfo.Name = name
+	}
+
+	return countryInfo
+}
+
+// networkPrefix converts a network returned by the reader, IPv4 networks of
+// IPv6 databases are returned as IPv4 prefixes
+func networkPrefix(network *net.IPNet) netip.Prefix {
+	addr, ok := netip.AddrFromSlice(network.IP)
+	if !ok {
+		return netip.Prefix{}
+	}
+	ones, bits := network.Mask.Size()
+	if addr.Is4In6() && bits == 128 && ones >= 96 {
+		return netip.PrefixFrom(addr.Unmap(), ones-96)
+	}
+	return netip.PrefixFrom(addr, ones)
 }
 
+// localizedName returns the English name, or any available name as fallback
+func localizedName(names map[string]string) string {
+	if names["en"] != "" {
+		return names["en"]
+	}
+
+	for _, name := range names {
+		return name
+	}
+
+	return ""
+}
+
+// Close stops automatic updates, aborts a running download and closes the
+// databases once in-flight lookups are done
 func (s *Service) Close() error {
+	if s.cancel != nil {
+		s.cancel()
+	}
+
 	if s.cron != nil {
 		s.cron.Stop()
 	}
 
-	if s.db != nil {
-		return s.db.Close()
+	// Wait for a running update to notice the cancellation, so it doesn't
+	// reload a database after it has been closed
+	s.updateMu.Lock()
+	defer s.updateMu.Unlock()
+
+	s.stopWatchers()
+
+	for _, d := range s.databases() {
+		d.close()
 	}
 
 	return nil
@@ -18,7 +18,19 @@
 package geoip
 
 import (
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"micro_geoip/internal/config"
+
+	"github.com/robfig/cron/v3"
 )
 
 func TestNewService(t *testing.T) {
@@ -27,6 +39,92 @@ func TestNewService(t *testing.T) {
 	t.Skip("Skipping TestNewService as it requires network access")
 }
 
+func TestNewServiceWithoutDatabase(t *testing.T) {
+	source := filepath.Join(t.TempDir(), "source.mmdb")
+	writeTestDatabase(t, source)
+	stub := &providerStub{data: gzipFile(t, source), etag: `"dbip-v1"`}
+
+	// DB-IP is down until released
+	release := make(chan struct{})
+	var failed atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+			stub.serve(w, r)
+		default:
+			failed.Add(1)
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	cfg := newTestConfig()
+	cfg.GeoIP.DatabasePath = filepath.Join(t.TempDir(), "GeoLite2-Country.mmdb")
+	cfg.GeoIP.DBIPUrl = server.URL + "/dbip-country-lite-{YYYY-MM}.mmdb.gz"
+	cfg.GeoIP.Download.RetryBackoff = "10ms"
+
+	s, err := NewService(cfg)
+	if err != nil {
+		t.Fatalf("Expected service to start without database, got %v", err)
+	}
+	defer s.Close()
+
+	if _, err := s.GetCountry("8.8.8.8"); !errors.Is(err, ErrDatabaseUnavailable) {
+		t.Errorf("Expected ErrDatabaseUnavailable before the download, got %v", err)
+	}
+	if s.Status()[0].Loaded {
+		t.Error("Expected country database not to be loaded yet")
+	}
+
+	// Let a few attempts fail before the provider recovers
+	for failed.Load() < 2 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(release)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		countryInfo, err := s.GetCountry("8.8.8.8")
+		if err == nil {
+			if countryInfo.Code != "US" {
+				t.Errorf("Expected country code 'US', got '%s'", countryInfo.Code)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the background download to load the database, got %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestOpenService(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.GeoIP.DatabasePath = filepath.Join(t.TempDir(), "GeoLite2-Country.mmdb")
+	cfg.GeoIP.DBIPUrl = "http://127.0.0.1:1/unreachable"
+
+	// Nothing is downloaded without an explicit update
+	s, err := OpenService(cfg)
+	if err != nil {
+		t.Fatalf("OpenService failed: %v", err)
+	}
+	if s.Status()[0].Loaded || s.cron != nil {
+		t.Error("Expected no database and no update schedule")
+	}
+	s.Close()
+
+	writeTestDatabase(t, cfg.GeoIP.DatabasePath)
+	s, err = OpenService(cfg)
+	if err != nil {
+		t.Fatalf("OpenService failed: %v", err)
+	}
+	defer s.Close()
+
+	if countryInfo, err := s.GetCountry("8.8.8.8"); err != nil || countryInfo.Code != "US" {
+		t.Errorf("Expected lookup in the existing database, got %v, %v", countryInfo, err)
+	}
+}
+
 func TestGetCountryWithMockService(t *testing.T) {
 	mockService := NewMockService()
 
@@ -114,3 +212,233 @@ func TestServiceClose(t *testing.T) {
 		t.Errorf("Close failed: %v", err)
 	}
 }
+
+func TestUpdateSchedule(t *testing.T) {
+	testCases := []struct {
+		interval string
+		schedule string
+		expected string
+	}{
+		{"720h", "", "@every 720h0m0s"},
+		{"24h", "", "@every 24h0m0s"},
+		{"invalid", "", "@every 720h0m0s"},
+		{"-1h", "", "@every 720h0m0s"},
+		{"24h", "30 3 * * 1", "30 3 * * 1"},
+		{"24h", "not a cron spec", "@every 24h0m0s"},
+	}
+
+	for _, tc := range testCases {
+		cfg := &config.Config{}
+		cfg.GeoIP.UpdateInterval = tc.interval
+		cfg.GeoIP.UpdateSchedule = tc.schedule
+
+		if spec := updateSchedule(cfg); spec != tc.expected {
+			t.Errorf("Interval %q, schedule %q: expected %q, got %q", tc.interval, tc.schedule, tc.expected, spec)
+		}
+	}
+}
+
+func TestReconfigure(t *testing.T) {
+	s := newTestService(t)
+	s.cron = cron.New()
+	s.setupAutoUpdate()
+	entry := s.cronEntry
+
+	cfg := newTestConfig()
+	cfg.GeoIP.UpdateSchedule = "0 3 * * 1"
+	cfg.GeoIP.UpdateJitter = "10m"
+	cfg.GeoIP.Providers = []config.ProviderConfig{
+		{Type: "file", Path: t.TempDir()},
+		{Type: "dbip"},
+	}
+
+	if err := s.Reconfigure(cfg); err != nil {
+		t.Fatalf("Reconfigure failed: %v", err)
+	}
+
+	if names := providerNames(s.country.providers); len(names) != 2 || names[0] != "file" || names[1] != "DB-IP" {
+		t.Errorf("Expected the new provider order, got %v", names)
+	}
+	if s.cronEntry == entry || len(s.cron.Entries()) != 1 {
+		t.Errorf("Expected the update schedule to be replaced, got %d entries", len(s.cron.Entries()))
+	}
+	if jitter := time.Duration(s.jitter.Load()); jitter != 10*time.Minute {
+		t.Errorf("Expected jitter of 10m, got %s", jitter)
+	}
+	if s.currentConfig() != cfg || s.country.downloader.config != cfg {
+		t.Error("Expected the new configuration to replace the previous one")
+	}
+
+	// An invalid provider chain leaves everything as it is
+	invalid := newTestConfig()
+	invalid.GeoIP.Providers = []config.ProviderConfig{{Type: "ftp"}}
+	if err := s.Reconfigure(invalid); err == nil {
+		t.Error("Expected Reconfigure to reject an unknown provider type")
+	}
+	if names := providerNames(s.country.providers); len(names) != 2 {
+		t.Errorf("Expected the previous providers to be kept, got %v", names)
+	}
+	if s.currentConfig() != cfg {
+		t.Error("Expected the previous configuration to be kept")
+	}
+}
+
+func providerNames(providers []Provider) []string {
+	names := make([]string, 0, len(providers))
+	for _, provider := range providers {
+		names = append(names, provider.Name())
+	}
+	return names
+}
+
+func TestDatabaseOutdated(t *testing.T) {
+	s := newTestService(t)
+
+	if s.databaseOutdated() {
+		t.Error("Expected database not to be outdated without update_if_older_than")
+	}
+
+	s.config.GeoIP.UpdateIfOlderThan = "24h"
+	if s.databaseOutdated() {
+		t.Error("Expected freshly built database not to be outdated")
+	}
+
+	// The build epoch has second precision, so wait for it to be in the past
+	s.config.GeoIP.UpdateIfOlderThan = "1ns"
+	time.Sleep(time.Second)
+	if !s.databaseOutdated() {
+		t.Error("Expected database to be outdated")
+	}
+}
+
+func TestCloseAbortsRunningUpdate(t *testing.T) {
+	s := newTestService(t)
+
+	// A provider that never finishes sending the database
+	started := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("partial"))
+		w.(http.Flusher).Flush()
+		close(started)
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+	s.config.GeoIP.DBIPUrl = server.URL
+
+	provider, err := newProvider(s.config, countrySettings(s.config), config.ProviderConfig{Type: "dbip"})
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	s.country.providers = []Provider{provider}
+
+	done := make(chan struct{})
+	go func() {
+		s.Update()
+		close(done)
+	}()
+	<-started
+
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected Close to abort the running update")
+	}
+
+	if _, err := s.GetCountry("8.8.8.8"); err == nil {
+		t.Error("Expected lookups to fail after Close")
+	}
+	assertNoTempFiles(t, s.config.GetDatabaseDir())
+}
+
+func TestReload(t *testing.T) {
+	s := newTestService(t)
+	checksum := s.Status()[0].Checksum
+
+	// An external tool replaces the database
+	time.Sleep(time.Second)
+	writeTestDatabase(t, s.config.GeoIP.DatabasePath)
+
+	if err := s.Reload(); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	if s.Status()[0].Checksum == checksum {
+		t.Error("Expected the replaced database to be loaded")
+	}
+
+	os.Remove(s.config.GeoIP.DatabasePath)
+	if err := s.Reload(); err == nil {
+		t.Error("Expected reload of a missing database to fail")
+	}
+	if _, err := s.GetCountry("8.8.8.8"); err != nil {
+		t.Errorf("Expected the loaded database to be kept after a failed reload, got %v", err)
+	}
+}
+
+func TestStatus(t *testing.T) {
+	s := newTestService(t)
+	newDBIPStub(t, s)
+
+	statuses := s.Status()
+	if len(statuses) != 1 {
+		t.Fatalf("Expected the country database only, got %d statuses", len(statuses))
+	}
+
+	status := statuses[0]
+	if !status.Loaded || status.Name != "country" || status.Type != "GeoLite2-Country" {
+		t.Errorf("Unexpected status of loaded database: %+v", status)
+	}
+	if status.Path != s.config.GeoIP.DatabasePath || time.Since(status.BuildTime) > time.Hour {
+		t.Errorf("Unexpected path or build time: %+v", status)
+	}
+	if status.IPVersion != 6 || len(status.Languages) != 1 || status.Languages[0] != "en" || status.NodeCount == 0 {
+		t.Errorf("Unexpected metadata: %+v", status)
+	}
+	if status.Description["en"] != "micro_geoip test database" {
+		t.Errorf("Unexpected description: %v", status.Description)
+	}
+	if sum, err := hashFile(s.config.GeoIP.DatabasePath); err != nil || status.Checksum != hex.EncodeToString(sum) {
+		t.Errorf("Expected checksum %x, got %s (%v)", sum, status.Checksum, err)
+	}
+	if !status.LastUpdate.Time.IsZero() {
+		t.Errorf("Expected no update to be recorded yet, got %+v", status.LastUpdate)
+	}
+
+	provider, err := newProvider(s.config, countrySettings(s.config), config.ProviderConfig{Type: "dbip"})
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	s.country.providers = []Provider{provider}
+	s.Update()
+
+	status = s.Status()[0]
+	if status.Source != "DB-IP" {
+		t.Errorf("Expected source DB-IP, got %q", status.Source)
+	}
+	if status.LastUpdate.Result != "success" || status.LastUpdate.Provider != "DB-IP" || status.LastUpdate.Time.IsZero() {
+		t.Errorf("Expected successful update from DB-IP, got %+v", status.LastUpdate)
+	}
+
+	s.Update()
+	if result := s.Status()[0].LastUpdate.Result; result != "not_modified" {
+		t.Errorf("Expected unchanged database to be reported as not_modified, got %s", result)
+	}
+
+	s.config.GeoIP.DBIPUrl = "http://127.0.0.1:1/unreachable"
+	if s.country.providers, err = newProviders(s.config, countrySettings(s.config)); err != nil {
+		t.Fatal(err)
+	}
+	s.Update()
+
+	status = s.Status()[0]
+	if status.LastUpdate.Result != "failed" || status.LastUpdate.Error == "" {
+		t.Errorf("Expected failed update with error, got %+v", status.LastUpdate)
+	}
+	if !status.Loaded {
+		t.Error("Expected the database to stay loaded after a failed update")
+	}
+}
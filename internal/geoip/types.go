@@ -17,8 +17,63 @@
 
 package geoip
 
+import (
+	"net/netip"
+	"time"
+)
+
 // CountryInfo represents country information from GeoIP lookup
 type CountryInfo struct {
 	Code string // ISO country code (e.g., "US")
 	Name string // Country name (e.g., "United States")
+
+	// Network of the database record the IP belongs to (e.g., 8.8.8.0/24),
+	// all IPs in it have the same information
+	Network netip.Prefix
+}
+
+// LocationInfo represents city level information from GeoIP lookup
+type LocationInfo struct {
+	CountryInfo
+	City            string  // City name (e.g., "Mountain View")
+	Subdivision     string  // Name of the largest subdivision (e.g., "California")
+	SubdivisionCode string  // ISO 3166-2 subdivision code (e.g., "US-CA")
+	PostalCode      string  // Postal code (e.g., "94043")
+	Latitude        float64 // Approximate latitude
+	Longitude       float64 // Approximate longitude
+	AccuracyRadius  uint16  // Accuracy radius of the coordinates in km
+	TimeZone        string  // IANA time zone (e.g., "America/Los_Angeles")
+}
+
+// ASNInfo represents the autonomous system an IP address belongs to
+type ASNInfo struct {
+	Number       uint         // Autonomous system number (e.g., 15169)
+	Organization string       // Organization operating the autonomous system (e.g., "GOOGLE")
+	Network      netip.Prefix // Network of the database record the IP belongs to
+}
+
+// DatabaseStatus describes the state of a database managed by the service
+type DatabaseStatus struct {
+	Name       string       // Kind of database (e.g., "country")
+	Loaded     bool         // Whether the database is loaded and used for lookups
+	Type       string       // Database type from the metadata (e.g., "GeoLite2-Country")
+	BuildTime  time.Time    // Build time from the metadata
+	Source     string       // Provider the installed database was taken from, if known
+	Path       string       // Location of the database file
+	LastUpdate UpdateStatus // Outcome of the last update attempt
+
+	// Further metadata of the loaded database
+	IPVersion   uint              // 4 for IPv4 only databases, 6 if they contain IPv6 too
+	Languages   []string          // Languages the names are available in
+	NodeCount   uint              // Number of nodes in the search tree
+	Description map[string]string // Description by language
+	Checksum    string            // Hex encoded SHA-256 digest of the loaded file
+}
+
+// UpdateStatus describes the outcome of an update attempt
+type UpdateStatus struct {
+	Time     time.Time // Zero if no update has been attempted yet
+	Provider string
+	Result   string // "success", "not_modified" or "failed"
+	Error    string
 }
@@ -0,0 +1,103 @@
+/*
+ * Copyright (C) 2025  GeorgH93
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package geoip
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/oschwald/geoip2-golang"
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// VerifyResult describes a database file checked by VerifyDatabase
+type VerifyResult struct {
+	Status  DatabaseStatus
+	Lookups []VerifyLookup // Sanity lookups of well known IPs
+}
+
+// VerifyLookup is the outcome of a sanity lookup
+type VerifyLookup struct {
+	IP     string
+	Result string // e.g. "US" or "AS15169 GOOGLE", empty if not found
+	Error  string
+}
+
+// VerifyDatabase opens a country, city or ASN database file, runs the checks
+// new downloads have to pass and looks up a few well known IPs in it
+func VerifyDatabase(path string) (*VerifyResult, error) {
+	sum, err := hashFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	reader, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	// Not loaded into the service, so the metrics are left alone
+	d := &database{edition: editionOf(reader.Metadata.DatabaseType), path: path}
+	handle := newReaderHandle(reader)
+	handle.checksum = hex.EncodeToString(sum)
+	d.reader.swap(handle)
+	defer d.reader.swap(nil)
+
+	result := &VerifyResult{Status: d.status()}
+
+	db, err := d.acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer db.release()
+
+	for _, ip := range databaseCanaryIPs(db.reader) {
+		lookup := VerifyLookup{IP: ip}
+		if d.edition.name == asnEdition.name {
+			var record geoip2.ASN
+			if err := db.reader.Lookup(net.ParseIP(ip), &record); err != nil {
+				lookup.Error = err.Error()
+			} else if record.AutonomousSystemNumber != 0 {
+				lookup.Result = fmt.Sprintf("AS%d %s", record.AutonomousSystemNumber, record.AutonomousSystemOrganization)
+			}
+		} else {
+			var record geoip2.Country
+			if err := db.reader.Lookup(net.ParseIP(ip), &record); err != nil {
+				lookup.Error = err.Error()
+			} else {
+				lookup.Result = record.Country.IsoCode
+			}
+		}
+		result.Lookups = append(result.Lookups, lookup)
+	}
+
+	return result, validateDatabase(path, d.edition)
+}
+
+// editionOf returns the edition matching a database type, e.g. the city
+// edition for "GeoLite2-City" or "DBIP-City-Lite"
+func editionOf(databaseType string) edition {
+	for _, ed := range []edition{asnEdition, cityEdition} {
+		if strings.Contains(databaseType, ed.typeMatch) {
+			return ed
+		}
+	}
+	return countryEdition
+}
@@ -0,0 +1,98 @@
+/*
+ * Copyright (C) 2025  GeorgH93
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package geoip
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestVerifyDatabase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "GeoLite2-Country.mmdb")
+	writeTestDatabase(t, path)
+
+	result, err := VerifyDatabase(path)
+	if err != nil {
+		t.Fatalf("Expected test database to pass verification, got %v", err)
+	}
+
+	if result.Status.Name != "country" || result.Status.Type != "GeoLite2-Country" || result.Status.Checksum == "" {
+		t.Errorf("Unexpected status: %+v", result.Status)
+	}
+
+	expected := map[string]string{"8.8.8.8": "US", "1.1.1.1": "AU", "2001:4860:4860::8888": "US"}
+	for _, lookup := range result.Lookups {
+		if lookup.Result != expected[lookup.IP] || lookup.Error != "" {
+			t.Errorf("Unexpected sanity lookup %+v", lookup)
+		}
+	}
+}
+
+func TestVerifyASNDatabase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "GeoLite2-ASN.mmdb")
+	writeTestASNDatabase(t, path)
+
+	result, err := VerifyDatabase(path)
+	if err != nil {
+		t.Fatalf("Expected ASN database to pass verification, got %v", err)
+	}
+
+	if result.Status.Name != "ASN" || len(result.Lookups) == 0 || result.Lookups[0].Result != "AS15169 GOOGLE" {
+		t.Errorf("Unexpected verification result: %+v", result)
+	}
+}
+
+func TestVerifyIPv4OnlyDatabase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ipv4.mmdb")
+	writeIPv4TestDatabase(t, path)
+
+	result, err := VerifyDatabase(path)
+	if err != nil {
+		t.Fatalf("Expected IPv4-only database to pass verification, got %v", err)
+	}
+
+	// IPv6 addresses can't be looked up, they are left out instead of reported as errors
+	for _, lookup := range result.Lookups {
+		if lookup.Error != "" || lookup.IP == "2001:4860:4860::8888" {
+			t.Errorf("Unexpected sanity lookup %+v", lookup)
+		}
+	}
+}
+
+func TestVerifyInvalidDatabase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "broken.mmdb")
+	if err := os.WriteFile(path, []byte("not a database"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := VerifyDatabase(path); err == nil {
+		t.Error("Expected verification of a broken file to fail")
+	}
+
+	// Databases without data for the sanity lookups fail the checks but still report their metadata
+	path = filepath.Join(t.TempDir(), "empty.mmdb")
+	writeDatabase(t, path, "GeoLite2-Country", nil)
+	result, err := VerifyDatabase(path)
+	if err == nil {
+		t.Error("Expected verification of an empty database to fail")
+	}
+	if result == nil || result.Status.Type != "GeoLite2-Country" {
+		t.Errorf("Expected metadata of the failed database, got %+v", result)
+	}
+}
@@ -0,0 +1,100 @@
+/*
+ * Copyright (C) 2025  GeorgH93
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package logging filters log messages by a level that can be changed at
+// runtime. Errors are logged with the standard log package and always shown.
+package logging
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+)
+
+type Level int32
+
+const (
+	LevelDebug Level = iota - 1
+	LevelInfo        // Default, logs requests and routine events like updates
+	LevelWarn        // Logs ignored settings and other unexpected but harmless conditions
+	LevelError       // Logs errors only
+)
+
+var current atomic.Int32
+
+// ParseLevel parses a level name, an empty name is the default info level
+func ParseLevel(name string) (Level, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "debug":
+		return LevelDebug, nil
+	case "", "info":
+		return LevelInfo, nil
+	case "warn", "warning":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	}
+	return LevelInfo, fmt.Errorf("invalid log level '%s', expected debug, info, warn or error", name)
+}
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelWarn:
+		return "warn"
+	case LevelError:
+		return "error"
+	}
+	return fmt.Sprintf("Level(%d)", int32(l))
+}
+
+// SetLevel changes the level, it is safe to call while logging
+func SetLevel(level Level) {
+	current.Store(int32(level))
+}
+
+// CurrentLevel returns the active level
+func CurrentLevel() Level {
+	return Level(current.Load())
+}
+
+// Enabled reports whether messages of the given level are logged
+func Enabled(level Level) bool {
+	return level >= CurrentLevel()
+}
+
+func Debugf(format string, args ...any) {
+	if Enabled(LevelDebug) {
+		log.Printf(format, args...)
+	}
+}
+
+func Infof(format string, args ...any) {
+	if Enabled(LevelInfo) {
+		log.Printf(format, args...)
+	}
+}
+
+func Warnf(format string, args ...any) {
+	if Enabled(LevelWarn) {
+		log.Printf(format, args...)
+	}
+}
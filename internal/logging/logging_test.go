@@ -0,0 +1,72 @@
+/*
+ * Copyright (C) 2025  GeorgH93
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package logging
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"testing"
+)
+
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		name  string
+		level Level
+	}{
+		{"", LevelInfo},
+		{"debug", LevelDebug},
+		{"INFO", LevelInfo},
+		{"warning", LevelWarn},
+		{" error ", LevelError},
+	}
+
+	for _, tt := range tests {
+		level, err := ParseLevel(tt.name)
+		if err != nil || level != tt.level {
+			t.Errorf("ParseLevel(%q) = %s, %v, want %s", tt.name, level, err, tt.level)
+		}
+	}
+
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Error("Expected an error for an invalid level")
+	}
+}
+
+func TestLevelFiltering(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	defer SetLevel(LevelInfo)
+
+	SetLevel(LevelWarn)
+	Debugf("debug")
+	Infof("info")
+	Warnf("warn")
+
+	if output := buf.String(); bytes.Contains(buf.Bytes(), []byte("info")) || !bytes.Contains(buf.Bytes(), []byte("warn")) {
+		t.Errorf("Expected only the warning to be logged at warn level, got %q", output)
+	}
+
+	buf.Reset()
+	SetLevel(LevelDebug)
+	Debugf("debug")
+	if !bytes.Contains(buf.Bytes(), []byte("debug")) {
+		t.Errorf("Expected debug messages at debug level, got %q", buf.String())
+	}
+}
@@ -0,0 +1,148 @@
+/*
+ * Copyright (C) 2025  GeorgH93
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+// Package metrics defines the Prometheus metrics exported by the service
+package metrics
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "micro_geoip"
+
+var (
+	HTTPRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by route, method and status code.",
+	}, []string{"route", "method", "status"})
+
+	HTTPRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by route, method and status code.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"route", "method", "status"})
+
+	Lookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "lookups_total",
+		Help:      "Country lookups by resulting country code, Unknown if the IP isn't in the database.",
+	}, []string{"country"})
+
+	LookupErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "lookup_errors_total",
+		Help:      "Country lookups that failed.",
+	})
+
+	CacheHits = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_hits_total",
+		Help:      "Lookups answered from the lookup cache by database.",
+	}, []string{"database"})
+
+	CacheMisses = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_misses_total",
+		Help:      "Lookups not found in the lookup cache by database.",
+	}, []string{"database"})
+
+	CacheEntries = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "cache_entries",
+		Help:      "Lookups held by the lookup cache.",
+	})
+
+	UpdateAttempt = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "update_last_attempt_timestamp_seconds",
+		Help:      "Time of the last update attempt by database and provider.",
+	}, []string{"database", "provider"})
+
+	UpdateSuccess = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "update_last_success_timestamp_seconds",
+		Help:      "Time of the last successful update (including unchanged databases) by database and provider.",
+	}, []string{"database", "provider"})
+
+	DownloadBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "download_bytes_total",
+		Help:      "Bytes downloaded by provider.",
+	}, []string{"provider"})
+
+	DownloadDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "download_duration_seconds",
+		Help:      "Duration of completed downloads by provider.",
+		Buckets:   prometheus.ExponentialBuckets(0.1, 2, 12),
+	}, []string{"provider"})
+)
+
+var (
+	buildTimeDesc = prometheus.NewDesc(namespace+"_database_build_timestamp_seconds",
+		"Build time of the loaded database.", []string{"database"}, nil)
+	ageDesc = prometheus.NewDesc(namespace+"_database_age_seconds",
+		"Time since the loaded database was built.", []string{"database"}, nil)
+)
+
+// databases tracks the build times of the loaded databases, the age is
+// calculated when the metrics are collected
+var databases = &databaseCollector{buildTimes: make(map[string]time.Time)}
+
+func init() {
+	prometheus.MustRegister(databases)
+}
+
+// SetDatabaseBuildTime records the build time of a newly loaded database
+func SetDatabaseBuildTime(database string, buildTime time.Time) {
+	databases.mu.Lock()
+	defer databases.mu.Unlock()
+	databases.buildTimes[database] = buildTime
+}
+
+// DeleteDatabase removes the metrics of a database that has been closed
+func DeleteDatabase(database string) {
+	databases.mu.Lock()
+	defer databases.mu.Unlock()
+	delete(databases.buildTimes, database)
+}
+
+type databaseCollector struct {
+	mu         sync.Mutex
+	buildTimes map[string]time.Time
+}
+
+func (c *databaseCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- buildTimeDesc
+	ch <- ageDesc
+}
+
+func (c *databaseCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for database, buildTime := range c.buildTimes {
+		ch <- prometheus.MustNewConstMetric(buildTimeDesc, prometheus.GaugeValue, float64(buildTime.Unix()), database)
+		ch <- prometheus.MustNewConstMetric(ageDesc, prometheus.GaugeValue, time.Since(buildTime).Seconds(), database)
+	}
+}
@@ -0,0 +1,70 @@
+/*
+ * Copyright (C) 2025  GeorgH93
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// collectGauges returns the values collected by c, keyed by metric description
+func collectGauges(t *testing.T, c prometheus.Collector) map[*prometheus.Desc]float64 {
+	t.Helper()
+
+	ch := make(chan prometheus.Metric, 10)
+	c.Collect(ch)
+	close(ch)
+
+	values := make(map[*prometheus.Desc]float64)
+	for metric := range ch {
+		var m dto.Metric
+		if err := metric.Write(&m); err != nil {
+			t.Fatalf("Failed to write metric: %v", err)
+		}
+		values[metric.Desc()] = m.GetGauge().GetValue()
+	}
+	return values
+}
+
+func TestDatabaseMetrics(t *testing.T) {
+	buildTime := time.Now().Add(-48 * time.Hour)
+	SetDatabaseBuildTime("country", buildTime)
+	defer DeleteDatabase("country")
+
+	values := collectGauges(t, databases)
+	if len(values) != 2 {
+		t.Fatalf("Expected build time and age, got %d metrics", len(values))
+	}
+
+	if values[buildTimeDesc] != float64(buildTime.Unix()) {
+		t.Errorf("Expected build time %d, got %v", buildTime.Unix(), values[buildTimeDesc])
+	}
+
+	// The age is calculated when collecting
+	if age := values[ageDesc]; age < 48*3600 || age > 48*3600+60 {
+		t.Errorf("Expected an age of about 48h, got %vs", age)
+	}
+
+	DeleteDatabase("country")
+	if values := collectGauges(t, databases); len(values) != 0 {
+		t.Errorf("Expected no database metrics after delete, got %d", len(values))
+	}
+}
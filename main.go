@@ -1,19 +1,82 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"log"
+	"os"
+	"os/signal"
+	"slices"
+	"syscall"
+	"time"
+
 	"micro_geoip/internal/api"
 	"micro_geoip/internal/config"
 	"micro_geoip/internal/geoip"
+	"micro_geoip/internal/logging"
 )
 
+// liveSettings are applied on SIGHUP, changes to other settings need a restart
+var liveSettings = []string{
+	"server.shutdown_timeout",
+	"server.log_level",
+	"server.max_batch_size",
+	"security.block_ip_param",
+	"security.admin_token",
+	"geoip.update_interval",
+	"geoip.update_schedule",
+	"geoip.update_jitter",
+	"geoip.maxmind_api_key",
+	"geoip.maxmind_url",
+	"geoip.dbip_url",
+	"geoip.dbip_expected_sha256",
+	"geoip.dbip_checksum_url",
+	"geoip.prefer_dbip",
+	"geoip.max_database_age",
+	"geoip.providers",
+	"geoip.city.maxmind_edition",
+	"geoip.city.dbip_url",
+	"geoip.city.providers",
+	"geoip.asn.maxmind_edition",
+	"geoip.asn.dbip_url",
+	"geoip.asn.providers",
+	"geoip.download.user_agent",
+	"geoip.download.retries",
+	"geoip.download.retry_backoff",
+}
+
 func main() {
-	// Load configuration
-	cfg, err := config.Load()
-	if err != nil {
-		log.Fatalf("Failed to load configuration: %v", err)
+	command, args := "serve", os.Args[1:]
+	if len(args) > 0 {
+		command, args = args[0], args[1:]
 	}
 
+	switch command {
+	case "serve":
+		serve()
+	case "lookup":
+		os.Exit(lookupCommand(args, os.Stdin, os.Stdout))
+	case "enrich":
+		os.Exit(enrichCommand(args, os.Stdin, os.Stdout))
+	case "networks":
+		os.Exit(networksCommand(args, os.Stdout))
+	case "update":
+		os.Exit(updateCommand(args))
+	case "verify":
+		os.Exit(verifyCommand(args, os.Stdout))
+	case "help", "-h", "-help", "--help":
+		usage(os.Stdout)
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command: %s\n\n", command)
+		usage(os.Stderr)
+		os.Exit(2)
+	}
+}
+
+// serve runs the API server until SIGINT or SIGTERM
+func serve() {
+	cfg := loadConfig()
+
 	// Initialize GeoIP service
 	geoipService, err := geoip.NewService(cfg)
 	if err != nil {
@@ -21,8 +84,115 @@ func main() {
 	}
 
 	// Start the API server
-	server := api.NewServer(cfg, geoipService)
-	if err := server.Start(); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+	server := api.NewServer(cfg, withCache(cfg, geoipService))
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.Start()
+	}()
+
+	// Wait for a termination signal, reloading the configuration on SIGHUP
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+
+wait:
+	for {
+		select {
+		case err := <-serverErr:
+			geoipService.Close()
+			log.Fatalf("Failed to start server: %v", err)
+		case <-hangup:
+			cfg = reloadConfig(cfg, server, geoipService)
+		case <-ctx.Done():
+			stop()
+			log.Println("Shutting down...")
+			break wait
+		}
+	}
+
+	// Drain in-flight requests before closing the database they use
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout(cfg))
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Failed to drain connections: %v", err)
+	}
+
+	if err := geoipService.Close(); err != nil {
+		log.Printf("Failed to close GeoIP service: %v", err)
+	}
+	log.Println("Shutdown complete")
+}
+
+// loadConfig loads the configuration and applies its log level, exiting if
+// it can't be loaded
+func loadConfig() *config.Config {
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	setLogLevel(cfg)
+	return cfg
+}
+
+// withCache puts the lookup cache in front of service if it is enabled
+func withCache(cfg *config.Config, service geoip.GeoIPService) geoip.GeoIPService {
+	if !cfg.GeoIP.Cache.Enabled {
+		return service
+	}
+	return geoip.NewCachedService(service, cfg)
+}
+
+// shutdownTimeout returns how long in-flight requests are drained on shutdown
+func shutdownTimeout(cfg *config.Config) time.Duration {
+	timeout, err := time.ParseDuration(cfg.Server.ShutdownTimeout)
+	if err != nil || timeout <= 0 {
+		log.Printf("Invalid shutdown timeout '%s', using default (30s)", cfg.Server.ShutdownTimeout)
+		return 30 * time.Second
+	}
+	return timeout
+}
+
+// setLogLevel applies the configured log level, falling back to info
+func setLogLevel(cfg *config.Config) {
+	level, err := logging.ParseLevel(cfg.Server.LogLevel)
+	if err != nil {
+		log.Printf("%v, using info", err)
+	}
+	logging.SetLevel(level)
+}
+
+// reloadConfig re-reads the configuration file and environment. The settings
+// that can change at runtime are applied, the others are logged as needing a
+// restart. The databases are reopened from disk either way. It returns the
+// configuration in use afterwards, the next reload is compared against it.
+func reloadConfig(running *config.Config, server *api.Server, geoipService *geoip.Service) *config.Config {
+	log.Println("Reloading configuration...")
+
+	cfg, err := config.Load()
+	if err == nil {
+		err = cfg.Validate()
+	}
+	if err != nil {
+		log.Printf("Invalid configuration, keeping the current one: %v", err)
+	} else if err := geoipService.Reconfigure(cfg); err != nil {
+		log.Printf("Invalid configuration, keeping the current one: %v", err)
+	} else {
+		server.Reconfigure(cfg)
+		setLogLevel(cfg)
+
+		for _, setting := range config.Changed(running, cfg) {
+			if !slices.Contains(liveSettings, setting) {
+				log.Printf("Changed setting %s only takes effect after a restart", setting)
+			}
+		}
+		log.Println("Configuration reloaded")
+		running = cfg
+	}
+
+	if err := geoipService.Reload(); err != nil {
+		log.Printf("Failed to reopen databases: %v", err)
 	}
-}
\ No newline at end of file
+	return running
+}
@@ -1,8 +1,14 @@
 package main
 
 import (
+	"bytes"
+	"log"
 	"os"
+	"strings"
 	"testing"
+
+	"micro_geoip/internal/api"
+	"micro_geoip/internal/geoip"
 )
 
 func TestMain(m *testing.M) {
@@ -27,4 +33,45 @@ func TestImports(t *testing.T) {
 	// This test ensures that all imports are valid
 	// The fact that this file compiles means the imports work
 	t.Log("All imports are valid")
-}
\ No newline at end of file
+}
+func TestReloadConfigTwice(t *testing.T) {
+	cfg := loadConfig()
+	service, err := geoip.OpenService(cfg)
+	if err != nil {
+		t.Fatalf("Failed to open GeoIP service: %v", err)
+	}
+	defer service.Close()
+	server := api.NewServer(cfg, service)
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	// A live and a restart-only change
+	os.Setenv("BLOCK_IP_PARAM", "true")
+	os.Setenv("PORT", "9091")
+	defer os.Unsetenv("BLOCK_IP_PARAM")
+
+	cfg = reloadConfig(cfg, server, service)
+	if !cfg.Security.BlockIPParam || cfg.Server.Port != "9091" {
+		t.Fatalf("Expected the reloaded configuration to be returned, got %+v", cfg)
+	}
+	if !strings.Contains(logs.String(), "server.port only takes effect after a restart") {
+		t.Errorf("Expected port change to be logged, got %q", logs.String())
+	}
+
+	// The second reload is compared against the first one, not the startup configuration
+	logs.Reset()
+	os.Setenv("BLOCK_IP_PARAM", "false")
+
+	cfg = reloadConfig(cfg, server, service)
+	if cfg.Security.BlockIPParam {
+		t.Error("Expected block_ip_param to be disabled by the second reload")
+	}
+	if strings.Contains(logs.String(), "server.port") {
+		t.Errorf("Expected no change to be reported for server.port, got %q", logs.String())
+	}
+	if !strings.Contains(logs.String(), "Configuration reloaded") {
+		t.Errorf("Expected second reload to succeed, got %q", logs.String())
+	}
+}